@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/salt"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -25,6 +29,22 @@ type jenkinsBackend struct {
 	*framework.Backend
 	client *jenkinsClient
 	lock   sync.RWMutex
+	// roleLock serializes password rotations of static roles
+	roleLock sync.Mutex
+	// checkOutLock serializes check-outs and check-ins of library sets
+	checkOutLock sync.Mutex
+
+	// salt hashes token values kept in the inventory
+	salt     *salt.Salt
+	saltLock sync.RWMutex
+
+	tidyStatus     *tidyStatus
+	tidyStatusLock sync.RWMutex
+	tidyRunning    uint32
+	lastAutoTidy   time.Time
+
+	lastIdleCheck       time.Time
+	lastUserExpiryCheck time.Time
 }
 
 // backend defines the target API backend
@@ -41,6 +61,7 @@ func backend() *jenkinsBackend {
 				configPrefix,
 				fmt.Sprintf("%s/*", usersPrefix),
 				fmt.Sprintf("%s/*", tokensPrefix),
+				fmt.Sprintf("%s/*", staticRolesPrefix),
 			},
 		},
 		Paths: framework.PathAppend(
@@ -49,13 +70,22 @@ func backend() *jenkinsBackend {
 			},
 			pathTokens(&b),
 			pathUsers(&b),
+			pathManageUsers(&b),
+			pathRoles(&b),
+			pathStaticRoles(&b),
+			pathLibrary(&b),
+			pathTidy(&b),
 		),
 		Secrets: []*framework.Secret{
 			b.jenkinsUser(),
 			b.jenkinsToken(),
+			b.jenkinsLibraryCreds(),
 		},
-		BackendType: logical.TypeLogical,
-		Invalidate:  b.invalidate,
+		BackendType:       logical.TypeLogical,
+		Invalidate:        b.invalidate,
+		PeriodicFunc:      b.periodicFunc,
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: walRollbackMinAge,
 	}
 	return &b
 }
@@ -71,11 +101,46 @@ func (b *jenkinsBackend) reset() {
 // invalidate clears an existing client configuration in
 // the backend
 func (b *jenkinsBackend) invalidate(ctx context.Context, key string) {
-	if key == configPrefix {
+	switch key {
+	case configPrefix:
 		b.reset()
+	case salt.DefaultLocation:
+		b.saltLock.Lock()
+		b.salt = nil
+		b.saltLock.Unlock()
 	}
 }
 
+// periodicFunc is invoked by Vault about once a minute to run
+// the scheduled work of the backend
+func (b *jenkinsBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	// Only the active node of the primary cluster may write to storage
+	replicationState := b.System().ReplicationState()
+	if !b.System().LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary|consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	var merr *multierror.Error
+
+	if err := b.rotateStaticRoles(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, fmt.Errorf("error rotating static roles: %w", err))
+	}
+
+	if err := b.revokeIdleTokens(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, fmt.Errorf("error revoking idle tokens: %w", err))
+	}
+
+	if err := b.expireUsers(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, fmt.Errorf("error expiring users: %w", err))
+	}
+
+	if err := b.autoTidy(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, fmt.Errorf("error tidying: %w", err))
+	}
+
+	return merr.ErrorOrNil()
+}
+
 // getClient locks the backend as it configures and creates a
 // a new client for the Jenkins API
 func (b *jenkinsBackend) getClient(ctx context.Context, s logical.Storage) (*jenkinsClient, error) {
@@ -108,9 +173,39 @@ func (b *jenkinsBackend) getClient(ctx context.Context, s logical.Storage) (*jen
 	return b.client, nil
 }
 
+// getSalt returns the salt of the mount, creating it on first use
+func (b *jenkinsBackend) getSalt(ctx context.Context, s logical.Storage) (*salt.Salt, error) {
+	b.saltLock.RLock()
+	if b.salt != nil {
+		defer b.saltLock.RUnlock()
+		return b.salt, nil
+	}
+	b.saltLock.RUnlock()
+
+	b.saltLock.Lock()
+	defer b.saltLock.Unlock()
+
+	if b.salt != nil {
+		return b.salt, nil
+	}
+
+	var err error
+	b.salt, err = salt.NewSalt(ctx, s, &salt.Config{
+		HashFunc: salt.SHA256Hash,
+		Location: salt.DefaultLocation,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.salt, nil
+}
+
 // backendHelp should contain help information for the backend
 const backendHelp = `
-The Jenkins secrets backend dynamically generates user tokens.
+The Jenkins secrets backend dynamically generates user tokens and users,
+rotates the passwords of existing users configured as static roles and
+lends existing users out of library sets.
 After mounting this backend, credentials to manage Jenkins user tokens
 must be configured with the "config/" endpoints.
 `
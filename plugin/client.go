@@ -1,11 +1,56 @@
 package jenkinssecretsengine
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/bndr/gojenkins"
 )
 
+const (
+	crumbIssuerContext    = "/crumbIssuer"
+	scriptTextContext     = "/scriptText"
+	whoAmIContext         = "/whoAmI"
+	revokeAPITokenContext = "/me/descriptorByName/jenkins.security.ApiTokenProperty/revoke"
+)
+
+// errNotFound is returned when the requested object doesn't exist in Jenkins
+var errNotFound = errors.New("not found in Jenkins")
+
+// jenkinsResponseError describes an unexpected response from Jenkins
+type jenkinsResponseError struct {
+	Action     string
+	StatusCode int
+}
+
+func (e *jenkinsResponseError) Error() string {
+	return fmt.Sprintf("error %s. Status is %d", e.Action, e.StatusCode)
+}
+
+// Is classifies not found responses as errNotFound
+func (e *jenkinsResponseError) Is(target error) bool {
+	return target == errNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// checkResponse returns an error for any response that isn't successful
+func checkResponse(resp *http.Response, action string) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	return &jenkinsResponseError{
+		Action:     action,
+		StatusCode: resp.StatusCode,
+	}
+}
+
 // jenkinsClient creates an object storing
 // the client.
 type jenkinsClient struct {
@@ -34,3 +79,346 @@ func newClient(config *jenkinsConfig) (*jenkinsClient, error) {
 
 	return &jenkinsClient{jenkins}, nil
 }
+
+// post sends a form encoded POST request to Jenkins including a CSRF crumb when
+// the instance issues one. Unlike the gojenkins requester it does not panic when
+// the crumb issuer cannot be reached.
+func (j *jenkinsClient) post(ctx context.Context, endpoint string, form url.Values, responseStruct interface{}) (*http.Response, error) {
+	var payload io.Reader
+	if form != nil {
+		payload = strings.NewReader(form.Encode())
+	}
+
+	ar := gojenkins.NewAPIRequest(http.MethodPost, endpoint, payload)
+	ar.SetHeader("Content-Type", "application/x-www-form-urlencoded")
+
+	crumb := map[string]string{}
+	resp, err := j.Requester.GetJSON(ctx, crumbIssuerContext, &crumb, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting jenkins crumb: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK && crumb["crumbRequestField"] != "" {
+		ar.SetHeader(crumb["crumbRequestField"], crumb["crumb"])
+		ar.SetHeader("Cookie", resp.Header.Get("set-cookie"))
+	}
+
+	return j.Requester.Do(ctx, ar, responseStruct)
+}
+
+// scriptResult is the envelope every script run through runScript prints
+type scriptResult struct {
+	Error    string          `json:"error"`
+	NotFound string          `json:"not_found"`
+	Result   json.RawMessage `json:"result"`
+}
+
+// runScript executes a groovy script on the Jenkins script console and decodes
+// the value the script assigns to `result` into out. The script must only embed
+// user supplied values through groovyString.
+func (j *jenkinsClient) runScript(ctx context.Context, script string, out interface{}) error {
+	var raw string
+	resp, err := j.post(ctx, scriptTextContext, url.Values{"script": {fmt.Sprintf(scriptWrapper, script)}}, &raw)
+	if err != nil {
+		return fmt.Errorf("error running jenkins script: %w", err)
+	}
+
+	if err := checkResponse(resp, "running jenkins script"); err != nil {
+		return err
+	}
+
+	// Only the last line is ours, anything before it was printed by Jenkins itself
+	lines := strings.Split(strings.TrimSpace(raw), "\n")
+	result := scriptResult{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return fmt.Errorf("error decoding jenkins script output: %w", err)
+	}
+
+	if result.NotFound != "" {
+		return fmt.Errorf("%w: %s", errNotFound, result.NotFound)
+	}
+
+	if result.Error != "" {
+		return fmt.Errorf("error running jenkins script: %s", result.Error)
+	}
+
+	if out == nil || len(result.Result) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(result.Result, out)
+}
+
+// setUserPassword replaces the password of an existing Jenkins user in the
+// Jenkins own user database.
+func (j *jenkinsClient) setUserPassword(ctx context.Context, username, password string) error {
+	return j.runScript(ctx, fmt.Sprintf(setUserPasswordScript, groovyString(username), groovyString(password)), nil)
+}
+
+// updateUser sets the full name and email of an existing Jenkins user, empty values are left unchanged
+func (j *jenkinsClient) updateUser(ctx context.Context, username, fullname, email string) error {
+	return j.runScript(ctx, fmt.Sprintf(updateUserScript, groovyString(username), groovyString(fullname), groovyString(email)), nil)
+}
+
+// jenkinsAPITokenInfo describes an API token as listed by Jenkins. Dates are
+// in milliseconds since the epoch and zero when not set.
+type jenkinsAPITokenInfo struct {
+	UUID         string `json:"uuid"`
+	Name         string `json:"name"`
+	CreationDate int64  `json:"creation_date"`
+	LastUseDate  int64  `json:"last_use_date"`
+	UseCounter   int    `json:"use_counter"`
+	Legacy       bool   `json:"legacy"`
+}
+
+// creationTime returns when the token was created
+func (token *jenkinsAPITokenInfo) creationTime() time.Time {
+	return millisToTime(token.CreationDate)
+}
+
+// lastUseTime returns when the token was last used, zero if it never was
+func (token *jenkinsAPITokenInfo) lastUseTime() time.Time {
+	return millisToTime(token.LastUseDate)
+}
+
+// toResponseData returns response data for the Jenkins side of a token
+func (token *jenkinsAPITokenInfo) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"jenkins_name":  token.Name,
+		"creation_date": token.creationTime(),
+		"use_counter":   token.UseCounter,
+		"last_use_date": nil,
+		"legacy":        token.Legacy,
+	}
+
+	if lastUse := token.lastUseTime(); !lastUse.IsZero() {
+		respData["last_use_date"] = lastUse
+	}
+
+	return respData
+}
+
+// jenkinsUserInfo describes a user as known by Jenkins
+type jenkinsUserInfo struct {
+	ID          string `json:"id"`
+	Fullname    string `json:"fullname"`
+	Email       string `json:"email"`
+	Description string `json:"description"`
+}
+
+// deleteUser deletes a user from Jenkins. Returns errNotFound if the user doesn't exist.
+func (j *jenkinsClient) deleteUser(ctx context.Context, username string) error {
+	resp, err := j.post(ctx, "/securityRealm/user/"+url.PathEscape(username)+"/doDelete", url.Values{"Submit": {"Yes"}}, nil)
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(resp, fmt.Sprintf("deleting Jenkins user %q", username))
+}
+
+// revokeAPIToken revokes an API token of the authenticated user.
+// Returns errNotFound if Jenkins reports the token or user as missing.
+func (j *jenkinsClient) revokeAPIToken(ctx context.Context, tokenID string) error {
+	resp, err := j.post(ctx, revokeAPITokenContext, url.Values{"tokenUuid": {tokenID}}, nil)
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(resp, fmt.Sprintf("revoking Jenkins API token %q", tokenID))
+}
+
+// jenkinsUserResponse is the user as returned by the Jenkins REST API
+type jenkinsUserResponse struct {
+	ID          string `json:"id"`
+	FullName    string `json:"fullName"`
+	Description string `json:"description"`
+	Property    []struct {
+		Address string `json:"address"`
+	} `json:"property"`
+}
+
+// getUser returns a user from Jenkins, nil if it doesn't exist
+func (j *jenkinsClient) getUser(ctx context.Context, username string) (*jenkinsUserInfo, error) {
+	user := &jenkinsUserResponse{}
+	resp, err := j.Requester.GetJSON(ctx, "/user/"+url.PathEscape(username), user, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Jenkins user %q: %w", username, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if err := checkResponse(resp, fmt.Sprintf("getting Jenkins user %q", username)); err != nil {
+		return nil, err
+	}
+
+	info := &jenkinsUserInfo{
+		ID:          user.ID,
+		Fullname:    user.FullName,
+		Description: user.Description,
+	}
+
+	// The email is exposed by the mailer plugin as one of the user properties
+	for _, property := range user.Property {
+		if property.Address != "" {
+			info.Email = property.Address
+		}
+	}
+
+	return info, nil
+}
+
+// listAPITokens returns the API tokens of a user
+func (j *jenkinsClient) listAPITokens(ctx context.Context, username string) ([]jenkinsAPITokenInfo, error) {
+	tokens := []jenkinsAPITokenInfo{}
+	if err := j.runScript(ctx, fmt.Sprintf(listAPITokensScript, groovyString(username)), &tokens); err != nil {
+		return nil, fmt.Errorf("error listing API tokens of Jenkins user %q: %w", username, err)
+	}
+
+	return tokens, nil
+}
+
+// getAPIToken returns the API token of a user with the given ID, nil if it doesn't exist
+func (j *jenkinsClient) getAPIToken(ctx context.Context, username, tokenID string) (*jenkinsAPITokenInfo, error) {
+	tokens, err := j.listAPITokens(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		if tokens[i].UUID == tokenID {
+			return &tokens[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// hasAPIToken returns whether a user still has the API token with the given ID
+func (j *jenkinsClient) hasAPIToken(ctx context.Context, username, tokenID string) (bool, error) {
+	token, err := j.getAPIToken(ctx, username, tokenID)
+	if err != nil {
+		return false, err
+	}
+
+	return token != nil, nil
+}
+
+// jenkinsWhoAmI is the identity Jenkins resolves for the credentials of a request
+type jenkinsWhoAmI struct {
+	Name          string   `json:"name"`
+	Authorities   []string `json:"authorities"`
+	Anonymous     bool     `json:"anonymous"`
+	Authenticated bool     `json:"authenticated"`
+}
+
+// whoAmI returns the identity Jenkins resolves for the credentials
+// of the client, nil if Jenkins rejects them
+func (j *jenkinsClient) whoAmI(ctx context.Context) (*jenkinsWhoAmI, error) {
+	identity := &jenkinsWhoAmI{}
+	resp, err := j.Requester.GetJSON(ctx, whoAmIContext, identity, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Jenkins identity: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, nil
+	}
+
+	if err := checkResponse(resp, "getting Jenkins identity"); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// getUserPermissions returns whether a user is granted each of the
+// main Jenkins permissions, keyed by permission ID such as Overall/Read
+func (j *jenkinsClient) getUserPermissions(ctx context.Context, username string) (map[string]bool, error) {
+	permissions := map[string]bool{}
+	if err := j.runScript(ctx, fmt.Sprintf(userPermissionsScript, groovyString(username)), &permissions); err != nil {
+		return nil, fmt.Errorf("error getting permissions of Jenkins user %q: %w", username, err)
+	}
+
+	return permissions, nil
+}
+
+// createUserAPIToken generates an API token for any user through the script console
+func (j *jenkinsClient) createUserAPIToken(ctx context.Context, username, tokenName string) (*gojenkins.APIToken, error) {
+	token := struct {
+		UUID  string `json:"uuid"`
+		Value string `json:"value"`
+	}{}
+	if err := j.runScript(ctx, fmt.Sprintf(createAPITokenScript, groovyString(username), groovyString(tokenName)), &token); err != nil {
+		return nil, fmt.Errorf("error creating API token for Jenkins user %q: %w", username, err)
+	}
+
+	return &gojenkins.APIToken{
+		Name:  tokenName,
+		UUID:  token.UUID,
+		Value: token.Value,
+	}, nil
+}
+
+// revokeUserAPIToken revokes an API token of any user through the script console.
+// Returns errNotFound if the user or token doesn't exist.
+func (j *jenkinsClient) revokeUserAPIToken(ctx context.Context, username, tokenID string) error {
+	if err := j.runScript(ctx, fmt.Sprintf(revokeAPITokenScript, groovyString(username), groovyString(tokenID)), nil); err != nil {
+		return fmt.Errorf("error revoking API token of Jenkins user %q: %w", username, err)
+	}
+
+	return nil
+}
+
+// listUsers returns every user known to Jenkins
+func (j *jenkinsClient) listUsers(ctx context.Context) ([]jenkinsUserInfo, error) {
+	users := []jenkinsUserInfo{}
+	if err := j.runScript(ctx, listUsersScript, &users); err != nil {
+		return nil, fmt.Errorf("error listing Jenkins users: %w", err)
+	}
+
+	return users, nil
+}
+
+// lockUser replaces the password of a user, removes its API tokens and invalidates its sessions
+func (j *jenkinsClient) lockUser(ctx context.Context, username, password string) error {
+	if err := j.runScript(ctx, fmt.Sprintf(lockUserScript, groovyString(username), groovyString(password)), nil); err != nil {
+		return fmt.Errorf("error locking Jenkins user %q: %w", username, err)
+	}
+
+	return nil
+}
+
+// disableUser disables a user where the security realm supports it. It returns
+// false if the user has no property to disable it.
+func (j *jenkinsClient) disableUser(ctx context.Context, username string) (bool, error) {
+	disabled := false
+	if err := j.runScript(ctx, fmt.Sprintf(disableUserScript, groovyString(username)), &disabled); err != nil {
+		return false, fmt.Errorf("error disabling Jenkins user %q: %w", username, err)
+	}
+
+	return disabled, nil
+}
+
+// setUserSSHKeys replaces the SSH public keys authorized for a user on the Jenkins CLI,
+// no keys removes them
+func (j *jenkinsClient) setUserSSHKeys(ctx context.Context, username string, keys []string) error {
+	if err := j.runScript(ctx, fmt.Sprintf(setUserSSHKeysScript, groovyString(username), groovyString(strings.Join(keys, "\n"))), nil); err != nil {
+		return fmt.Errorf("error setting SSH public keys of Jenkins user %q: %w", username, err)
+	}
+
+	return nil
+}
+
+// setUserDescription replaces the description of a user
+func (j *jenkinsClient) setUserDescription(ctx context.Context, username, description string) error {
+	return j.runScript(ctx, fmt.Sprintf(setUserDescriptionScript, groovyString(username), groovyString(description)), nil)
+}
+
+// millisToTime converts milliseconds since the epoch as used by Jenkins to a time
+func millisToTime(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
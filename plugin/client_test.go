@@ -0,0 +1,23 @@
+package jenkinssecretsengine
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckResponse tests that only not found responses are classified as errNotFound
+func TestCheckResponse(t *testing.T) {
+	require.NoError(t, checkResponse(&http.Response{StatusCode: http.StatusOK}, "testing"))
+
+	err := checkResponse(&http.Response{StatusCode: http.StatusNotFound}, "testing")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, errNotFound))
+
+	err = checkResponse(&http.Response{StatusCode: http.StatusForbidden}, "testing")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, errNotFound))
+	require.Equal(t, "error testing. Status is 403", err.Error())
+}
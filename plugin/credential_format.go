@@ -0,0 +1,95 @@
+package jenkinssecretsengine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Ready-to-use renderings of a credential that can be requested with the format field
+const (
+	formatBasicAuthHeader = "basic_auth_header"
+	formatNetrc           = "netrc"
+	formatJenkinsCLIEnv   = "jenkins_cli_env"
+	formatEnvExport       = "env_export"
+)
+
+// credentialFormats are the supported values of the format field
+var credentialFormats = []string{
+	formatBasicAuthHeader,
+	formatNetrc,
+	formatJenkinsCLIEnv,
+	formatEnvExport,
+}
+
+// formatFieldDescription describes the format field of paths returning credentials
+var formatFieldDescription = fmt.Sprintf("Comma separated list of extra renderings of the credential to return. Supported values: %s.", strings.Join(credentialFormats, ", "))
+
+// validateCredentialFormats returns an error for any unsupported format, or for
+// a format that can't be rendered from the Jenkins URL
+func validateCredentialFormats(formats []string, jenkinsURL string) error {
+	for _, format := range formats {
+		if !containsString(credentialFormats, format) {
+			return fmt.Errorf("unsupported format %q, must be one of: %s", format, strings.Join(credentialFormats, ", "))
+		}
+
+		if format == formatNetrc {
+			u, err := url.Parse(jenkinsURL)
+			if err != nil || u.Hostname() == "" {
+				return fmt.Errorf("format %q needs a Jenkins URL with a host, got %q", format, jenkinsURL)
+			}
+		}
+	}
+
+	return nil
+}
+
+// renderCredentialFormats renders the credential of a Jenkins user in every requested
+// format, keyed by format so the values can be added to a response
+func renderCredentialFormats(formats []string, jenkinsURL, username, secret string) (map[string]interface{}, error) {
+	rendered := map[string]interface{}{}
+
+	for _, format := range formats {
+		switch format {
+		case formatBasicAuthHeader:
+			rendered[format] = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+secret))
+		case formatNetrc:
+			u, err := url.Parse(jenkinsURL)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing Jenkins URL: %w", err)
+			}
+			rendered[format] = fmt.Sprintf("machine %s login %s password %s", u.Hostname(), username, secret)
+		case formatJenkinsCLIEnv:
+			rendered[format] = fmt.Sprintf("JENKINS_URL=%s\nJENKINS_USER_ID=%s\nJENKINS_API_TOKEN=%s", jenkinsURL, username, secret)
+		case formatEnvExport:
+			rendered[format] = fmt.Sprintf("export JENKINS_USER_ID=%s\nexport JENKINS_API_TOKEN=%s", shellQuote(username), shellQuote(secret))
+		default:
+			return nil, fmt.Errorf("unsupported format %q", format)
+		}
+	}
+
+	return rendered, nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// addCredentialFormats adds the requested renderings of a credential to a response. The
+// formats are validated before the credential is created, so the credential is returned
+// with a warning rather than lost if they still can't be rendered.
+func addCredentialFormats(resp *logical.Response, config *jenkinsConfig, formats []string, username, secret string) {
+	rendered, err := renderCredentialFormats(formats, config.URL, username, secret)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("error rendering credential formats: %s", err))
+		return
+	}
+
+	for key, value := range rendered {
+		resp.Data[key] = value
+	}
+}
@@ -0,0 +1,148 @@
+package jenkinssecretsengine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+// internalDataVersion is the version of the internal data written to user and
+// token leases. Leases without a version were issued by older plugin versions
+// which stored TTLs as time.Duration, in nanoseconds.
+const internalDataVersion = 1
+
+// leaseInternalData is the internal data of user and token leases. TTLs are in seconds.
+type leaseInternalData struct {
+	Username  string   `mapstructure:"username"`
+	Fullname  string   `mapstructure:"fullname"`
+	Email     string   `mapstructure:"email"`
+	TokenID   string   `mapstructure:"token_id"`
+	TokenName string   `mapstructure:"token_name"`
+	TokenIDs  []string `mapstructure:"token_ids"`
+	Usernames []string `mapstructure:"usernames"`
+	LeaseID   string   `mapstructure:"lease_id"`
+	TTL       int64    `mapstructure:"ttl"`
+	MaxTTL    int64    `mapstructure:"max_ttl"`
+	Version   int      `mapstructure:"version"`
+}
+
+// newLeaseInternalData returns the internal data of a new lease with the given TTLs
+func newLeaseInternalData(ttl, maxTTL time.Duration) *leaseInternalData {
+	return &leaseInternalData{
+		TTL:     int64(ttl.Seconds()),
+		MaxTTL:  int64(maxTTL.Seconds()),
+		Version: internalDataVersion,
+	}
+}
+
+// toMap returns the internal data as stored with the lease
+func (data *leaseInternalData) toMap() map[string]interface{} {
+	internalData := map[string]interface{}{
+		"ttl":     data.TTL,
+		"max_ttl": data.MaxTTL,
+		"version": data.Version,
+	}
+
+	if data.Username != "" {
+		internalData["username"] = data.Username
+	}
+
+	if data.Fullname != "" || data.Email != "" {
+		internalData["fullname"] = data.Fullname
+		internalData["email"] = data.Email
+	}
+
+	if data.TokenID != "" {
+		internalData["token_id"] = data.TokenID
+		internalData["token_name"] = data.TokenName
+	}
+
+	if len(data.TokenIDs) > 0 {
+		internalData["token_ids"] = data.TokenIDs
+	}
+
+	if len(data.Usernames) > 0 {
+		internalData["usernames"] = data.Usernames
+	}
+
+	if data.LeaseID != "" {
+		internalData["lease_id"] = data.LeaseID
+	}
+
+	return internalData
+}
+
+// tokenIDs returns the IDs of the tokens of a token lease, which holds several
+// when issued for a batch
+func (data *leaseInternalData) tokenIDs() []string {
+	if len(data.TokenIDs) > 0 {
+		return data.TokenIDs
+	}
+	return []string{data.TokenID}
+}
+
+// usernames returns the users of a user lease, which holds several when issued
+// for a bulk request
+func (data *leaseInternalData) usernames() []string {
+	if len(data.Usernames) > 0 {
+		return data.Usernames
+	}
+	return []string{data.Username}
+}
+
+// ttl returns the TTL of the lease
+func (data *leaseInternalData) ttl() time.Duration {
+	return time.Duration(data.TTL) * time.Second
+}
+
+// maxTTL returns the max TTL of the lease
+func (data *leaseInternalData) maxTTL() time.Duration {
+	return time.Duration(data.MaxTTL) * time.Second
+}
+
+// decodeLeaseInternalData decodes the internal data of a user or token lease.
+// Numbers may be of any type depending on whether the lease went through
+// storage, and leases of older plugin versions are migrated.
+func decodeLeaseInternalData(secret *logical.Secret) (*leaseInternalData, error) {
+	if secret == nil {
+		return nil, fmt.Errorf("secret is missing")
+	}
+
+	data := &leaseInternalData{}
+	if err := mapstructure.WeakDecode(secret.InternalData, data); err != nil {
+		return nil, fmt.Errorf("invalid secret internal data: %w", err)
+	}
+
+	switch data.Version {
+	case 0:
+		// TTLs were stored as time.Duration
+		data.TTL = int64(time.Duration(data.TTL) / time.Second)
+		data.MaxTTL = int64(time.Duration(data.MaxTTL) / time.Second)
+		data.Version = internalDataVersion
+	case internalDataVersion:
+	default:
+		return nil, fmt.Errorf("unsupported secret internal data version %d", data.Version)
+	}
+
+	if data.TTL < 0 || data.MaxTTL < 0 {
+		return nil, fmt.Errorf("invalid ttl in secret internal data")
+	}
+
+	return data, nil
+}
+
+// renewResponse returns the response of a lease renewal using the TTLs of the internal data
+func renewResponse(req *logical.Request, data *leaseInternalData) *logical.Response {
+	resp := &logical.Response{Secret: req.Secret}
+
+	if data.TTL > 0 {
+		resp.Secret.TTL = data.ttl()
+	}
+	if data.MaxTTL > 0 {
+		resp.Secret.MaxTTL = data.maxTTL()
+	}
+
+	return resp
+}
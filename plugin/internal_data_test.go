@@ -0,0 +1,57 @@
+package jenkinssecretsengine
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDecodeLeaseInternalData tests decoding current and legacy lease internal data
+func TestDecodeLeaseInternalData(t *testing.T) {
+	t.Run("Current version after storage", func(t *testing.T) {
+		internalData := newLeaseInternalData(time.Hour, 2*time.Hour)
+		internalData.TokenID = "testTokenID"
+
+		// Leases are JSON encoded in storage, so numbers come back as float64
+		raw, err := json.Marshal(internalData.toMap())
+		require.NoError(t, err)
+		secret := &logical.Secret{}
+		require.NoError(t, json.Unmarshal(raw, &secret.InternalData))
+
+		data, err := decodeLeaseInternalData(secret)
+		require.NoError(t, err)
+		require.Equal(t, "testTokenID", data.TokenID)
+		require.Equal(t, time.Hour, data.ttl())
+		require.Equal(t, 2*time.Hour, data.maxTTL())
+	})
+
+	t.Run("Legacy nanoseconds", func(t *testing.T) {
+		data, err := decodeLeaseInternalData(&logical.Secret{
+			InternalData: map[string]interface{}{
+				"username": testUserUsername,
+				"ttl":      float64(time.Hour),
+				"max_ttl":  time.Duration(0),
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, testUserUsername, data.Username)
+		require.Equal(t, time.Hour, data.ttl())
+		require.Equal(t, time.Duration(0), data.maxTTL())
+		require.Equal(t, internalDataVersion, data.Version)
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		_, err := decodeLeaseInternalData(&logical.Secret{
+			InternalData: map[string]interface{}{"ttl": []string{"invalid"}},
+		})
+		require.Error(t, err)
+
+		_, err = decodeLeaseInternalData(&logical.Secret{
+			InternalData: map[string]interface{}{"version": internalDataVersion + 1},
+		})
+		require.Error(t, err)
+	})
+}
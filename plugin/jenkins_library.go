@@ -0,0 +1,286 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	jenkinsLibraryCredsType = "jenkins_library_creds"
+	libraryCheckOutPrefix   = "library-check-out"
+
+	checkOutIDLength = 20
+)
+
+var (
+	// errNoAccountAvailable is returned when every account of a set is checked out
+	errNoAccountAvailable = errors.New("no service accounts available for check-out")
+	// errNotCheckedOut is returned when checking in an account that is not checked out
+	errNotCheckedOut = errors.New("service account is not checked out")
+)
+
+// jenkinsLibrarySet defines a pool of existing Jenkins users
+// that can be checked out exclusively
+type jenkinsLibrarySet struct {
+	Name                      string        `json:"name"`
+	ServiceAccountNames       []string      `json:"service_account_names"`
+	TTL                       time.Duration `json:"ttl"`
+	MaxTTL                    time.Duration `json:"max_ttl"`
+	DisableCheckInEnforcement bool          `json:"disable_check_in_enforcement"`
+}
+
+// toResponseData returns response data for a library set
+func (set *jenkinsLibrarySet) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"name":                         set.Name,
+		"service_account_names":        set.ServiceAccountNames,
+		"ttl":                          int64(set.TTL.Seconds()),
+		"max_ttl":                      int64(set.MaxTTL.Seconds()),
+		"disable_check_in_enforcement": set.DisableCheckInEnforcement,
+	}
+	return respData
+}
+
+// hasServiceAccount returns whether the account belongs to the set
+func (set *jenkinsLibrarySet) hasServiceAccount(username string) bool {
+	return containsString(set.ServiceAccountNames, username)
+}
+
+// jenkinsCheckOut records who is currently holding a service account
+type jenkinsCheckOut struct {
+	CheckOutTime     time.Time `json:"check_out_time"`
+	CheckOutID       string    `json:"check_out_id"`
+	SetName          string    `json:"set_name"`
+	BorrowerEntityID string    `json:"borrower_entity_id"`
+}
+
+// jenkinsLibraryCreds defines the credentials of a checked out service
+// account and how they should be checked in or renewed.
+func (b *jenkinsBackend) jenkinsLibraryCreds() *framework.Secret {
+	return &framework.Secret{
+		Type: jenkinsLibraryCredsType,
+		Fields: map[string]*framework.FieldSchema{
+			"username": {
+				Type:        framework.TypeString,
+				Description: "Jenkins service account",
+			},
+			"password": {
+				Type:        framework.TypeString,
+				Description: "Password of the Jenkins service account",
+			},
+		},
+		Revoke: b.libraryCredsRevoke,
+		Renew:  b.libraryCredsRenew,
+	}
+}
+
+// libraryCredsRevoke checks in the service account when its lease ends
+func (b *jenkinsBackend) libraryCredsRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	username, checkOutID, err := libraryCredsInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	b.checkOutLock.Lock()
+	defer b.checkOutLock.Unlock()
+
+	checkOut, err := getCheckOut(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account was already checked in, possibly checked out again by someone else
+	if checkOut == nil || checkOut.CheckOutID != checkOutID {
+		return nil, nil
+	}
+
+	if err := b.checkIn(ctx, req.Storage, username); err != nil {
+		return nil, fmt.Errorf("error checking in service account: %w", err)
+	}
+
+	return nil, nil
+}
+
+// libraryCredsRenew extends the lease of a checked out service account
+func (b *jenkinsBackend) libraryCredsRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	username, checkOutID, err := libraryCredsInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	checkOut, err := getCheckOut(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if checkOut == nil || checkOut.CheckOutID != checkOutID {
+		return nil, fmt.Errorf("service account %q is no longer checked out by this lease", username)
+	}
+
+	set, err := getLibrarySet(ctx, req.Storage, checkOut.SetName)
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, fmt.Errorf("library set %q no longer exists", checkOut.SetName)
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	if set.TTL > 0 {
+		resp.Secret.TTL = set.TTL
+	}
+	if set.MaxTTL > 0 {
+		resp.Secret.MaxTTL = set.MaxTTL
+	}
+
+	return resp, nil
+}
+
+// checkOut reserves the first available account of the set for the entity and
+// sets a new password for it. The caller must hold the check out lock.
+func (b *jenkinsBackend) checkOut(ctx context.Context, s logical.Storage, set *jenkinsLibrarySet, entityID string) (*jenkinsCheckOut, string, string, error) {
+	for _, username := range set.ServiceAccountNames {
+		existing, err := getCheckOut(ctx, s, username)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		if existing != nil {
+			continue
+		}
+
+		client, err := b.getClient(ctx, s)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		password, err := generatePassword()
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		if err := client.setUserPassword(ctx, username, password); err != nil {
+			return nil, "", "", fmt.Errorf("error rotating password for Jenkins user %q: %w", username, err)
+		}
+
+		checkOutID, err := base62.Random(checkOutIDLength)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		checkOut := &jenkinsCheckOut{
+			CheckOutTime:     time.Now(),
+			CheckOutID:       checkOutID,
+			SetName:          set.Name,
+			BorrowerEntityID: entityID,
+		}
+
+		entry, err := logical.StorageEntryJSON(getCheckOutPath(username), checkOut)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		if err := s.Put(ctx, entry); err != nil {
+			return nil, "", "", err
+		}
+
+		return checkOut, username, password, nil
+	}
+
+	return nil, "", "", errNoAccountAvailable
+}
+
+// checkIn rotates the password of a checked out account so the borrower
+// can no longer use it and makes it available again. The caller must hold
+// the check out lock.
+func (b *jenkinsBackend) checkIn(ctx context.Context, s logical.Storage, username string) error {
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	if err := client.setUserPassword(ctx, username, password); err != nil {
+		return fmt.Errorf("error rotating password for Jenkins user %q: %w", username, err)
+	}
+
+	return s.Delete(ctx, getCheckOutPath(username))
+}
+
+// libraryCredsInternalData returns the service account and check out ID of a lease
+func libraryCredsInternalData(secret *logical.Secret) (string, string, error) {
+	username, ok := secret.InternalData["username"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("invalid value for username in secret internal data")
+	}
+
+	checkOutID, ok := secret.InternalData["check_out_id"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("invalid value for check_out_id in secret internal data")
+	}
+
+	return username, checkOutID, nil
+}
+
+// getLibrarySet gets the library set from the Vault storage API
+func getLibrarySet(ctx context.Context, s logical.Storage, name string) (*jenkinsLibrarySet, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing set name")
+	}
+
+	entry, err := s.Get(ctx, getLibrarySetPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var set jenkinsLibrarySet
+
+	if err := entry.DecodeJSON(&set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// getCheckOut gets the check out of a service account from the Vault storage API
+func getCheckOut(ctx context.Context, s logical.Storage, username string) (*jenkinsCheckOut, error) {
+	entry, err := s.Get(ctx, getCheckOutPath(username))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var checkOut jenkinsCheckOut
+
+	if err := entry.DecodeJSON(&checkOut); err != nil {
+		return nil, err
+	}
+	return &checkOut, nil
+}
+
+// getLibrarySetPath returns the library set storage path such as /library/set
+func getLibrarySetPath(name string) string {
+	return fmt.Sprintf("%s/%s", libraryPrefix, name)
+}
+
+// getCheckOutPath returns the check out storage path such as /library-check-out/user
+func getCheckOutPath(username string) string {
+	return fmt.Sprintf("%s/%s", libraryCheckOutPrefix, username)
+}
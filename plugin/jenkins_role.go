@@ -0,0 +1,88 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// jenkinsRole defines which existing Jenkins users API tokens can be issued for
+type jenkinsRole struct {
+	UserPolicy       userPolicy    `json:"user_policy"`
+	Name             string        `json:"name"`
+	AllowedUsernames []string      `json:"allowed_usernames"`
+	TTL              time.Duration `json:"ttl"`
+	MaxTTL           time.Duration `json:"max_ttl"`
+}
+
+// toResponseData returns response data for a role
+func (role *jenkinsRole) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"name":              role.Name,
+		"allowed_usernames": role.AllowedUsernames,
+		"ttl":               int64(role.TTL.Seconds()),
+		"max_ttl":           int64(role.MaxTTL.Seconds()),
+	}
+	role.UserPolicy.addResponseData(respData)
+	return respData
+}
+
+// allowsUsername returns whether the role may issue tokens for a user.
+// Allowed usernames may contain globs such as svc-*.
+func (role *jenkinsRole) allowsUsername(username string) bool {
+	return strutil.StrListContainsGlob(role.AllowedUsernames, username)
+}
+
+// createOnBehalfToken generates an API token for another user through the script console
+func createOnBehalfToken(ctx context.Context, j *jenkinsClient, username, tokenName string) (*jenkinsToken, error) {
+	token, err := j.createUserAPIToken(ctx, username, tokenName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jenkinsToken{
+		Token:   token.Value,
+		TokenID: token.UUID,
+	}, nil
+}
+
+// getRole gets the role from the Vault storage API
+func getRole(ctx context.Context, s logical.Storage, name string) (*jenkinsRole, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing role name")
+	}
+
+	entry, err := s.Get(ctx, getRolePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role jenkinsRole
+
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// putRole writes the role to the Vault storage API
+func putRole(ctx context.Context, s logical.Storage, role *jenkinsRole) error {
+	entry, err := logical.StorageEntryJSON(getRolePath(role.Name), role)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getRolePath returns the role storage path such as /roles/role
+func getRolePath(name string) string {
+	return fmt.Sprintf("%s/%s", rolesPrefix, name)
+}
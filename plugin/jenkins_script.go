@@ -0,0 +1,211 @@
+package jenkinssecretsengine
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// scriptWrapper wraps every script sent to the script console so that the
+// value assigned to `result`, or the exception raised, is printed as JSON.
+// Scripts throw NoSuchElementException when the object they act on is missing.
+const scriptWrapper = `import groovy.json.JsonOutput
+def result = null
+def output = null
+try {
+%s
+	output = [result: result]
+} catch (NoSuchElementException e) {
+	output = [not_found: e.message]
+} catch (Throwable e) {
+	output = [error: e.toString()]
+}
+println JsonOutput.toJson(output)
+`
+
+// setUserPasswordScript sets the password of a user in the Jenkins user database.
+// Arguments: username, password
+const setUserPasswordScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+user.addProperty(hudson.security.HudsonPrivateSecurityRealm.Details.fromPlainPassword(%s))
+`
+
+// setUserDescriptionScript sets the description of a user.
+// Arguments: username, description
+const setUserDescriptionScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+user.setDescription(%s)
+user.save()
+`
+
+// updateUserScript sets the full name and email of a user, empty values are left unchanged.
+// The mailer plugin is looked up by name since it may not be installed.
+// Arguments: username, fullname, email
+const updateUserScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def fullname = %s
+if (fullname) {
+	user.setFullName(fullname)
+}
+def email = %s
+if (email) {
+	def mailer = jenkins.model.Jenkins.get().pluginManager.uberClassLoader.loadClass('hudson.tasks.Mailer$UserProperty')
+	user.addProperty(mailer.getConstructor(String).newInstance(email))
+}
+user.save()
+`
+
+// listAPITokensScript lists the API tokens of a user with their usage statistics.
+// Arguments: username
+const listAPITokensScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def property = user.getProperty(jenkins.security.ApiTokenProperty)
+result = property == null ? [] : property.tokenList.collect { token ->
+	[
+		uuid: token.uuid,
+		name: token.name,
+		creation_date: token.creationDate?.time,
+		use_counter: token.useCounter,
+		last_use_date: token.lastUseDate?.time,
+		legacy: token.isLegacy
+	]
+}
+`
+
+// listUsersScript lists every user known to Jenkins. The mailer plugin
+// is looked up by name since it may not be installed.
+const listUsersScript = `
+result = hudson.model.User.getAll().collect { user ->
+	[
+		id: user.id,
+		fullname: user.fullName,
+		email: user.allProperties.find { it.class.name == 'hudson.tasks.Mailer$UserProperty' }?.address,
+		description: user.description
+	]
+}
+`
+
+// userPermissionsScript checks the main Jenkins permissions of a user.
+// Arguments: username
+const userPermissionsScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def auth = user.impersonate()
+def acl = jenkins.model.Jenkins.get().getACL()
+result = [
+	jenkins.model.Jenkins.ADMINISTER,
+	jenkins.model.Jenkins.READ,
+	hudson.model.Item.CREATE,
+	hudson.model.Item.READ,
+	hudson.model.Item.BUILD,
+	hudson.model.Item.CONFIGURE,
+	hudson.model.Item.DELETE
+].collectEntries { permission ->
+	[(permission.group.title.toString() + '/' + permission.name): acl.hasPermission(auth, permission)]
+}
+`
+
+// lockUserScript locks a user out of Jenkins by replacing its password, removing
+// its API tokens and SSH public keys and invalidating its sessions. The seed and
+// SSH properties are looked up by name since Jenkins may lack them.
+// Arguments: username, password
+const lockUserScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+user.addProperty(hudson.security.HudsonPrivateSecurityRealm.Details.fromPlainPassword(%s))
+user.addProperty(new jenkins.security.ApiTokenProperty())
+def sshKeys = user.allProperties.find { it.class.name == 'org.jenkinsci.main.modules.cli.auth.ssh.UserPropertyImpl' }
+if (sshKeys != null) {
+	user.addProperty(sshKeys.class.newInstance(''))
+}
+user.allProperties.find { it.class.name == 'jenkins.security.seed.UserSeedProperty' }?.renewSeed()
+user.save()
+`
+
+// disableUserScript disables a user through a user property offering it, as
+// some security realms do, and invalidates its sessions. The result tells
+// whether such a property was found.
+// Arguments: username
+const disableUserScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def property = user.allProperties.find { !it.metaClass.respondsTo(it, 'setDisabled').isEmpty() }
+result = property != null
+if (property != null) {
+	property.setDisabled(true)
+	user.allProperties.find { it.class.name == 'jenkins.security.seed.UserSeedProperty' }?.renewSeed()
+	user.save()
+}
+`
+
+// setUserSSHKeysScript replaces the SSH public keys a user authenticates to the Jenkins
+// CLI with. The property is looked up by name since it comes from the sshd module.
+// Arguments: username, authorized keys
+const setUserSSHKeysScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def property
+try {
+	property = jenkins.model.Jenkins.get().pluginManager.uberClassLoader.loadClass('org.jenkinsci.main.modules.cli.auth.ssh.UserPropertyImpl')
+} catch (ClassNotFoundException e) {
+	throw new IllegalStateException('SSH authentication for the Jenkins CLI is not available')
+}
+user.addProperty(property.newInstance(%s))
+user.save()
+`
+
+// createAPITokenScript generates an API token for a user.
+// Arguments: username, token name
+const createAPITokenScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def property = user.getProperty(jenkins.security.ApiTokenProperty)
+if (property == null) {
+	property = new jenkins.security.ApiTokenProperty()
+	user.addProperty(property)
+}
+def token = property.tokenStore.generateNewToken(%s)
+user.save()
+result = [uuid: token.tokenUuid, value: token.plainValue]
+`
+
+// revokeAPITokenScript revokes an API token of a user.
+// Arguments: username, token ID
+const revokeAPITokenScript = `
+def user = hudson.model.User.getById(%s, false)
+if (user == null) {
+	throw new NoSuchElementException('user does not exist')
+}
+def property = user.getProperty(jenkins.security.ApiTokenProperty)
+if (property == null || property.tokenStore.revokeToken(%s) == null) {
+	throw new NoSuchElementException('token does not exist')
+}
+user.save()
+`
+
+// groovyString renders s as a groovy expression evaluating to s. The value is
+// base64 encoded so it can never terminate the literal it is placed in.
+func groovyString(s string) string {
+	return fmt.Sprintf("new String('%s'.decodeBase64(), 'UTF-8')", base64.StdEncoding.EncodeToString([]byte(s)))
+}
@@ -0,0 +1,29 @@
+package jenkinssecretsengine
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGroovyString ensures values can never escape the groovy literal they are rendered into
+func TestGroovyString(t *testing.T) {
+	values := []string{
+		"admin",
+		"user' + Jenkins.instance.doSafeExit(null) + '",
+		`"""${Jenkins.instance}"""`,
+		"line\nbreak\\",
+	}
+
+	for _, value := range values {
+		rendered := groovyString(value)
+		encoded := strings.TrimSuffix(strings.TrimPrefix(rendered, "new String('"), "'.decodeBase64(), 'UTF-8')")
+
+		require.NotContains(t, encoded, "'")
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+		require.Equal(t, value, string(decoded))
+	}
+}
@@ -0,0 +1,358 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// passwordLength is the length of passwords generated by the plugin
+	passwordLength = 32
+
+	credentialTypePassword = "password"
+	credentialTypeAPIToken = "api_token"
+)
+
+// jenkinsStaticRole defines an existing Jenkins user whose
+// password or API token is owned and rotated by Vault
+type jenkinsStaticRole struct {
+	LastVaultRotation   time.Time     `json:"last_vault_rotation"`
+	PreviousTokenExpiry time.Time     `json:"previous_token_expiry"`
+	Name                string        `json:"name"`
+	Username            string        `json:"username"`
+	CredentialType      string        `json:"credential_type"`
+	Password            string        `json:"password"`
+	Token               string        `json:"token,omitempty"`
+	TokenID             string        `json:"token_id,omitempty"`
+	PreviousToken       string        `json:"previous_token,omitempty"`
+	PreviousTokenID     string        `json:"previous_token_id,omitempty"`
+	RotationPeriod      time.Duration `json:"rotation_period"`
+	GracePeriod         time.Duration `json:"rotation_grace_period"`
+}
+
+// toResponseData returns response data for a static role
+func (role *jenkinsStaticRole) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"name":                  role.Name,
+		"username":              role.Username,
+		"credential_type":       role.credentialType(),
+		"rotation_period":       int64(role.RotationPeriod.Seconds()),
+		"rotation_grace_period": int64(role.GracePeriod.Seconds()),
+		"last_vault_rotation":   role.LastVaultRotation,
+	}
+	return respData
+}
+
+// toCredsResponseData returns the current credentials of a static role
+func (role *jenkinsStaticRole) toCredsResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"username":            role.Username,
+		"rotation_period":     int64(role.RotationPeriod.Seconds()),
+		"last_vault_rotation": role.LastVaultRotation,
+		"ttl":                 int64(role.ttl().Seconds()),
+	}
+
+	if role.credentialType() == credentialTypePassword {
+		respData["password"] = role.Password
+		return respData
+	}
+
+	respData["token"] = role.Token
+	respData["token_id"] = role.TokenID
+	if role.PreviousTokenID != "" {
+		respData["previous_token"] = role.PreviousToken
+		respData["previous_token_id"] = role.PreviousTokenID
+		respData["previous_token_expiry"] = role.PreviousTokenExpiry
+	}
+	return respData
+}
+
+// credentialType returns the type of credential managed by the role.
+// Roles created before API token roles existed manage passwords.
+func (role *jenkinsStaticRole) credentialType() string {
+	if role.CredentialType == "" {
+		return credentialTypePassword
+	}
+	return role.CredentialType
+}
+
+// nextRotation returns when the password of the role is due to be rotated
+func (role *jenkinsStaticRole) nextRotation() time.Time {
+	return role.LastVaultRotation.Add(role.RotationPeriod)
+}
+
+// ttl returns how long the current password is valid for
+func (role *jenkinsStaticRole) ttl() time.Duration {
+	ttl := time.Until(role.nextRotation())
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// rotateStaticRole rotates the credential of the static role in Jenkins and then stores it.
+func (b *jenkinsBackend) rotateStaticRole(ctx context.Context, s logical.Storage, role *jenkinsStaticRole) error {
+	var err error
+	if role.credentialType() == credentialTypeAPIToken {
+		err = b.rotateStaticToken(ctx, s, role)
+	} else {
+		err = b.rotateStaticPassword(ctx, s, role)
+	}
+	if err != nil {
+		return err
+	}
+
+	role.LastVaultRotation = time.Now()
+
+	return putStaticRole(ctx, s, role)
+}
+
+// rotateStaticPassword sets a newly generated password for the user of the static role
+func (b *jenkinsBackend) rotateStaticPassword(ctx context.Context, s logical.Storage, role *jenkinsStaticRole) error {
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	if err := client.setUserPassword(ctx, role.Username, password); err != nil {
+		return fmt.Errorf("error rotating password for Jenkins user %q: %w", role.Username, err)
+	}
+
+	role.Password = password
+
+	return nil
+}
+
+// rotateStaticToken generates a new API token for the user of the static role through the
+// script console, so the password of the user is left alone. The previous token stays valid
+// for the grace period of the role so that consumers holding it are not interrupted.
+func (b *jenkinsBackend) rotateStaticToken(ctx context.Context, s logical.Storage, role *jenkinsStaticRole) error {
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	// Only one previous token is kept, rotating within the grace period ends it early.
+	// It is revoked before a new token exists so that a failure leaves nothing behind.
+	if role.PreviousTokenID != "" {
+		if err := deleteTokenOf(ctx, client, role.Username, role.PreviousTokenID); err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("error revoking previous token for Jenkins user %q: %w", role.Username, err)
+		}
+
+		role.PreviousToken, role.PreviousTokenID, role.PreviousTokenExpiry = "", "", time.Time{}
+		if err := putStaticRole(ctx, s, role); err != nil {
+			return err
+		}
+	}
+
+	walID, jenkinsName, err := putTokenWAL(ctx, s, role.Username, role.Name)
+	if err != nil {
+		return err
+	}
+
+	token, err := createOnBehalfToken(ctx, client, role.Username, jenkinsName)
+	if err != nil {
+		return fmt.Errorf("error rotating token for Jenkins user %q: %w", role.Username, err)
+	}
+
+	if role.TokenID != "" {
+		role.PreviousToken = role.Token
+		role.PreviousTokenID = role.TokenID
+		role.PreviousTokenExpiry = time.Now().Add(role.GracePeriod)
+	}
+	role.Token = token.Token
+	role.TokenID = token.TokenID
+
+	if err := putStaticRole(ctx, s, role); err != nil {
+		if deleteErr := deleteTokenOf(ctx, client, role.Username, token.TokenID); deleteErr != nil {
+			return fmt.Errorf("error storing static role: %v, error revoking new token: %w", err, deleteErr)
+		}
+		return fmt.Errorf("error storing static role: %w", err)
+	}
+
+	b.deleteWAL(ctx, s, walID)
+
+	// Without a grace period the previous token is revoked right away. If that
+	// fails it is kept as an expired previous token and revoked on the next run.
+	if role.GracePeriod == 0 {
+		if err := b.expirePreviousStaticToken(ctx, s, role); err != nil {
+			b.Logger().Warn("error revoking previous static role token", "role", role.Name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// revokeStaticTokens revokes the API tokens issued for a static role
+func (b *jenkinsBackend) revokeStaticTokens(ctx context.Context, s logical.Storage, role *jenkinsStaticRole) error {
+	if role.credentialType() != credentialTypeAPIToken {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for _, tokenID := range []string{role.TokenID, role.PreviousTokenID} {
+		if tokenID == "" {
+			continue
+		}
+		if err := deleteTokenOf(ctx, client, role.Username, tokenID); err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("error revoking token for Jenkins user %q: %w", role.Username, err)
+		}
+	}
+
+	return nil
+}
+
+// expirePreviousStaticToken revokes the previous token of a static
+// role once its grace period has elapsed.
+func (b *jenkinsBackend) expirePreviousStaticToken(ctx context.Context, s logical.Storage, role *jenkinsStaticRole) error {
+	if role.PreviousTokenID == "" || time.Now().Before(role.PreviousTokenExpiry) {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteTokenOf(ctx, client, role.Username, role.PreviousTokenID); err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("error revoking previous token for Jenkins user %q: %w", role.Username, err)
+	}
+
+	role.PreviousToken, role.PreviousTokenID, role.PreviousTokenExpiry = "", "", time.Time{}
+
+	return putStaticRole(ctx, s, role)
+}
+
+// staticRoleTokens returns the users of API token static roles and the IDs of the tokens
+// they hold. These tokens have no inventory entry, the static roles track them instead.
+func staticRoleTokens(ctx context.Context, s logical.Storage) ([]string, map[string]bool, error) {
+	names, err := s.List(ctx, fmt.Sprintf("%s/", staticRolesPrefix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usernames := []string{}
+	tokenIDs := map[string]bool{}
+	for _, name := range names {
+		role, err := getStaticRole(ctx, s, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if role == nil || role.credentialType() != credentialTypeAPIToken {
+			continue
+		}
+
+		usernames = append(usernames, role.Username)
+		for _, tokenID := range []string{role.TokenID, role.PreviousTokenID} {
+			if tokenID != "" {
+				tokenIDs[tokenID] = true
+			}
+		}
+	}
+
+	return usernames, tokenIDs, nil
+}
+
+// rotateStaticRoles rotates the credential of every static role whose
+// rotation period has elapsed and ends elapsed token grace periods.
+func (b *jenkinsBackend) rotateStaticRoles(ctx context.Context, s logical.Storage) error {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	names, err := s.List(ctx, fmt.Sprintf("%s/", staticRolesPrefix))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		role, err := getStaticRole(ctx, s, name)
+		if err != nil {
+			return err
+		}
+
+		if role == nil {
+			continue
+		}
+
+		if err := b.expirePreviousStaticToken(ctx, s, role); err != nil {
+			b.Logger().Error("error expiring previous static role token", "role", name, "error", err)
+		}
+
+		if time.Now().Before(role.nextRotation()) {
+			continue
+		}
+
+		if err := b.rotateStaticRole(ctx, s, role); err != nil {
+			// Keep rotating the remaining roles, this one is retried on the next run
+			b.Logger().Error("error rotating static role", "role", name, "error", err)
+			continue
+		}
+
+		b.Logger().Debug("rotated static role", "role", name)
+	}
+
+	return nil
+}
+
+// getStaticRole gets the static role from the Vault storage API
+func getStaticRole(ctx context.Context, s logical.Storage, name string) (*jenkinsStaticRole, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing role name")
+	}
+
+	entry, err := s.Get(ctx, getStaticRolePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role jenkinsStaticRole
+
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// putStaticRole writes the static role to the Vault storage API
+func putStaticRole(ctx context.Context, s logical.Storage, role *jenkinsStaticRole) error {
+	entry, err := logical.StorageEntryJSON(getStaticRolePath(role.Name), role)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getStaticRolePath returns the static role storage path such as /static-roles/role
+func getStaticRolePath(name string) string {
+	return fmt.Sprintf("%s/%s", staticRolesPrefix, name)
+}
+
+// generatePassword returns a random password for Jenkins users managed by Vault
+func generatePassword() (string, error) {
+	password, err := base62.Random(passwordLength)
+	if err != nil {
+		return "", fmt.Errorf("error generating password: %w", err)
+	}
+
+	return password, nil
+}
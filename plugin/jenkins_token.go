@@ -2,15 +2,22 @@ package jenkinssecretsengine
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	jenkinsTokenType = "jenkins_token"
+	// jenkinsTokenNamePrefix marks the Jenkins tokens created by the plugin
+	jenkinsTokenNamePrefix = "vault-"
+	// idleCheckInterval is how often tokens are checked for idleness
+	idleCheckInterval = 5 * time.Minute
 )
 
 // jenkinsToken defines a secret for the Jenkins token
@@ -22,6 +29,52 @@ type jenkinsToken struct {
 	MaxTTL  time.Duration `json:"max_ttl"`
 }
 
+// jenkinsTokenEntry is the inventory entry stored for every token issued by
+// the plugin. It must never hold the token value, only its salted hash. Tokens
+// revoked for being idle keep their entry until the lease ends so that renewals
+// can report the revocation.
+type jenkinsTokenEntry struct {
+	CreationTime  time.Time     `json:"creation_time"`
+	IdleRevokedAt time.Time     `json:"idle_revoked_at,omitempty"`
+	TokenID       string        `json:"token_id"`
+	Name          string        `json:"token_name"`
+	EntityID      string        `json:"entity_id"`
+	TokenHash     string        `json:"token_hash"`
+	Username      string        `json:"username,omitempty"`
+	RoleName      string        `json:"role_name,omitempty"`
+	TTL           time.Duration `json:"ttl"`
+	MaxTTL        time.Duration `json:"max_ttl"`
+}
+
+// toKeyInfo returns the key info of a token listed under /tokens
+func (entry *jenkinsTokenEntry) toKeyInfo() map[string]interface{} {
+	keyInfo := map[string]interface{}{
+		"token_name":    entry.Name,
+		"creation_time": entry.CreationTime,
+		"ttl":           int64(entry.TTL.Seconds()),
+		"max_ttl":       int64(entry.MaxTTL.Seconds()),
+		"entity_id":     entry.EntityID,
+	}
+
+	// Tokens issued for other users through a role
+	if entry.Username != "" {
+		keyInfo["username"] = entry.Username
+		keyInfo["role_name"] = entry.RoleName
+	}
+	if !entry.IdleRevokedAt.IsZero() {
+		keyInfo["idle_revoked_at"] = entry.IdleRevokedAt
+	}
+	return keyInfo
+}
+
+// owner returns the Jenkins user the token was issued for
+func (entry *jenkinsTokenEntry) owner(config *jenkinsConfig) string {
+	if entry.Username != "" {
+		return entry.Username
+	}
+	return config.Username
+}
+
 // toResponseData returns response data for a token
 func (token *jenkinsToken) toResponseData() map[string]interface{} {
 	respData := map[string]interface{}{
@@ -48,53 +101,183 @@ func (b *jenkinsBackend) jenkinsToken() *framework.Secret {
 	}
 }
 
-// tokenRevoke removes the token from the Vault storage API and calls the client to revoke the token
+// tokenRevoke removes the tokens of the lease from the Vault storage API and calls the client
+// to revoke them. Every token is revoked on its own, so one failing doesn't keep the others.
 func (b *jenkinsBackend) tokenRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("error getting client: %w", err)
 	}
 
-	tokenID := ""
-	tokenIDRaw, ok := req.Secret.InternalData["token_id"]
-	if ok {
-		tokenID, ok = tokenIDRaw.(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid value for token_id in secret internal data")
+	data, err := decodeLeaseInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var merr *multierror.Error
+	for _, tokenID := range data.tokenIDs() {
+		if err := b.revokeToken(ctx, req.Storage, client, data.Username, tokenID); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("token %q: %w", tokenID, err))
 		}
 	}
 
-	// Delete from Jenkins
-	if err := deleteToken(ctx, client, tokenID); err != nil {
-		return nil, fmt.Errorf("error revoking user token: %w", err)
+	return nil, merr.ErrorOrNil()
+}
+
+// revokeToken revokes a token in Jenkins and removes it from the inventory. The username
+// is set for tokens issued for other users and empty for those of the configured user.
+func (b *jenkinsBackend) revokeToken(ctx context.Context, s logical.Storage, client *jenkinsClient, username, tokenID string) error {
+	// Delete from Jenkins, a token revoked out-of-band is already revoked
+	err := deleteTokenOf(ctx, client, username, tokenID)
+	if errors.Is(err, errNotFound) {
+		b.Logger().Warn("token was already revoked in Jenkins", "token_id", tokenID)
+	} else if err != nil {
+		return fmt.Errorf("error revoking user token: %w", err)
 	}
 
-	return nil, nil
+	// Delete from inventory
+	err = s.Delete(ctx, getTokenPath(tokenID))
+	if err != nil {
+		return fmt.Errorf("error removing token from storage: %w", err)
+	}
+
+	return nil
 }
 
-// tokenRenew renews the ttl time in vault
+// tokenRenew renews the ttl time in vault as long as every token of the lease is still valid in Jenkins
 func (b *jenkinsBackend) tokenRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	ttlRaw, ok := req.Secret.InternalData["ttl"]
-	if !ok {
-		return nil, fmt.Errorf("secret is missing ttl internal data")
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
 	}
-	maxTtlRaw, ok := req.Secret.InternalData["max_ttl"]
-	if !ok {
-		return nil, fmt.Errorf("secret is missing max_ttl internal data")
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
 	}
 
-	resp := &logical.Response{Secret: req.Secret}
-	ttl := time.Duration(ttlRaw.(float64)) * time.Second
-	maxTtl := time.Duration(maxTtlRaw.(float64)) * time.Second
+	data, err := decodeLeaseInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
 
-	if ttl > 0 {
-		resp.Secret.TTL = ttl
+	// Tokens issued for other users record their owner
+	username := config.Username
+	if data.Username != "" {
+		username = data.Username
 	}
-	if maxTtl > 0 {
-		resp.Secret.MaxTTL = maxTtl
+
+	for _, tokenID := range data.tokenIDs() {
+		entry, err := getTokenFromStorage(ctx, req.Storage, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != nil && !entry.IdleRevokedAt.IsZero() {
+			return nil, fmt.Errorf("token %q was revoked for being idle at %s", tokenID, entry.IdleRevokedAt.Format(time.RFC3339))
+		}
+
+		exists, err := client.hasAPIToken(ctx, username, tokenID)
+		if err != nil {
+			return nil, fmt.Errorf("error checking token: %w", err)
+		}
+
+		if !exists {
+			return nil, fmt.Errorf("token %q no longer exists in Jenkins", tokenID)
+		}
 	}
 
-	return resp, nil
+	return renewResponse(req, data), nil
+}
+
+// revokeIdleTokens revokes the tokens in the inventory that have not been used for longer than
+// the configured idle timeout, according to Jenkins. Their entries are kept and marked until the
+// lease is revoked, since the plugin can't revoke leases itself.
+func (b *jenkinsBackend) revokeIdleTokens(ctx context.Context, s logical.Storage) error {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config == nil || config.IdleTimeout <= 0 || time.Since(b.lastIdleCheck) < idleCheckInterval {
+		return nil
+	}
+
+	b.lastIdleCheck = time.Now()
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	tokenIDs, err := s.List(ctx, fmt.Sprintf("%s/", tokensPrefix))
+	if err != nil {
+		return err
+	}
+
+	// Tokens of every owner in the inventory, listed once per owner
+	jenkinsTokens := map[string]map[string]jenkinsAPITokenInfo{}
+
+	var merr *multierror.Error
+	for _, tokenID := range tokenIDs {
+		entry, err := getTokenFromStorage(ctx, s, tokenID)
+		if err != nil {
+			return err
+		}
+
+		if entry == nil || !entry.IdleRevokedAt.IsZero() {
+			continue
+		}
+
+		owner := entry.owner(config)
+		if _, ok := jenkinsTokens[owner]; !ok {
+			tokens, err := client.listAPITokens(ctx, owner)
+			if err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("user %q: %w", owner, err))
+			}
+
+			jenkinsTokens[owner] = map[string]jenkinsAPITokenInfo{}
+			for _, token := range tokens {
+				jenkinsTokens[owner][token.UUID] = token
+			}
+		}
+
+		// Tokens gone from Jenkins are reported by renewals and tidy
+		token, ok := jenkinsTokens[owner][tokenID]
+		if !ok {
+			continue
+		}
+
+		// A token that was never used is idle since its creation
+		lastUse := token.lastUseTime()
+		if lastUse.IsZero() {
+			lastUse = token.creationTime()
+		}
+
+		if time.Since(lastUse) < config.IdleTimeout {
+			continue
+		}
+
+		if err := deleteTokenOf(ctx, client, entry.Username, tokenID); err != nil && !errors.Is(err, errNotFound) {
+			merr = multierror.Append(merr, fmt.Errorf("token %q: %w", tokenID, err))
+			continue
+		}
+
+		entry.IdleRevokedAt = time.Now()
+		if err := putTokenEntry(ctx, s, entry); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("token %q: %w", tokenID, err))
+			continue
+		}
+
+		b.Logger().Info("revoked idle token", "token_id", tokenID, "token_name", entry.Name, "username", owner,
+			"entity_id", entry.EntityID, "last_use", lastUse, "use_counter", token.UseCounter)
+	}
+
+	return merr.ErrorOrNil()
 }
 
 // createToken calls the jenkins client to generate and return a new token
@@ -110,12 +293,81 @@ func createToken(ctx context.Context, j *jenkinsClient, tokenName string) (*jenk
 	}, nil
 }
 
+// getTokenFromStorage gets the token inventory entry from the Vault storage API
+func getTokenFromStorage(ctx context.Context, s logical.Storage, tokenID string) (*jenkinsTokenEntry, error) {
+	if tokenID == "" {
+		return nil, fmt.Errorf("missing token_id")
+	}
+
+	entry, err := s.Get(ctx, getTokenPath(tokenID))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	var token jenkinsTokenEntry
+
+	if err := entry.DecodeJSON(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// putTokenEntry writes the inventory entry of a token to the Vault storage API
+func putTokenEntry(ctx context.Context, s logical.Storage, token *jenkinsTokenEntry) error {
+	entry, err := logical.StorageEntryJSON(getTokenPath(token.TokenID), token)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// findTokenByHash returns the inventory entry of the token with the given salted hash, nil if there is none
+func findTokenByHash(ctx context.Context, s logical.Storage, tokenHash string) (*jenkinsTokenEntry, error) {
+	tokenIDs, err := s.List(ctx, fmt.Sprintf("%s/", tokensPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tokenID := range tokenIDs {
+		entry, err := getTokenFromStorage(ctx, s, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != nil && entry.TokenHash != "" && subtle.ConstantTimeCompare([]byte(entry.TokenHash), []byte(tokenHash)) == 1 {
+			return entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// getTokenPath returns the token inventory storage path such as /tokens/token_id
+func getTokenPath(tokenID string) string {
+	return fmt.Sprintf("%s/%s", tokensPrefix, tokenID)
+}
+
 // deleteToken revokes the token
 func deleteToken(ctx context.Context, j *jenkinsClient, tokenID string) error {
-	err := j.RevokeAPIToken(ctx, tokenID)
+	err := j.revokeAPIToken(ctx, tokenID)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// deleteTokenOf revokes a token of another user through the script console,
+// or of the configured user when username is empty
+func deleteTokenOf(ctx context.Context, j *jenkinsClient, username, tokenID string) error {
+	if username == "" {
+		return deleteToken(ctx, j, tokenID)
+	}
+
+	return j.revokeUserAPIToken(ctx, username, tokenID)
+}
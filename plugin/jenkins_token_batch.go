@@ -0,0 +1,123 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// tokenBatchIndexPlaceholder is replaced by the index of each token in a batch name template
+	tokenBatchIndexPlaceholder    = "{{index}}"
+	defaultTokenBatchNameTemplate = "batch-" + tokenBatchIndexPlaceholder
+	// maxTokenBatchCount is the largest number of tokens issued by a single request
+	maxTokenBatchCount = 100
+	// tokenBatchConcurrency is how many tokens of a batch are created in parallel
+	tokenBatchConcurrency = 5
+)
+
+// tokenNameRegex matches the token names accepted under /tokens
+var tokenNameRegex = regexp.MustCompile(fmt.Sprintf("^%s$", framework.GenericNameRegex("name")))
+
+// tokenBatchNames renders the names of the tokens of a batch from a name template
+func tokenBatchNames(nameTemplate string, count int) ([]string, error) {
+	if !strings.Contains(nameTemplate, tokenBatchIndexPlaceholder) {
+		return nil, fmt.Errorf("name_template must contain %s", tokenBatchIndexPlaceholder)
+	}
+
+	names := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		name := strings.ReplaceAll(nameTemplate, tokenBatchIndexPlaceholder, strconv.Itoa(i))
+		if !tokenNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid token name %q rendered from name_template", name)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// createTokenBatch creates a token for each name with bounded parallelism and returns them with
+// the IDs of their WAL entries. If any creation fails, the tokens already created are revoked.
+func (b *jenkinsBackend) createTokenBatch(ctx context.Context, s logical.Storage, username string, names []string) ([]*jenkinsToken, []string, error) {
+	walIDs := make([]string, len(names))
+	jenkinsNames := make([]string, len(names))
+	for i, name := range names {
+		walID, jenkinsName, err := putTokenWAL(ctx, s, username, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		walIDs[i], jenkinsNames[i] = walID, jenkinsName
+	}
+
+	tokens := make([]*jenkinsToken, len(names))
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, tokenBatchConcurrency)
+	for i, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			tokens[i], errs[i] = b.createToken(ctx, s, jenkinsNames[i])
+			if tokens[i] != nil {
+				tokens[i].Name = name
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	var merr *multierror.Error
+	for _, err := range errs {
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	if merr.ErrorOrNil() == nil {
+		return tokens, walIDs, nil
+	}
+
+	b.rollbackTokenBatch(ctx, s, tokens, walIDs)
+
+	return nil, nil, fmt.Errorf("error creating token batch, created tokens were rolled back: %w", merr)
+}
+
+// rollbackTokenBatch revokes the tokens of a batch that failed and removes them from the
+// inventory. WAL entries are left for the rollback wherever a token may remain in
+// Jenkins, including failed creations that may have completed anyway.
+func (b *jenkinsBackend) rollbackTokenBatch(ctx context.Context, s logical.Storage, tokens []*jenkinsToken, walIDs []string) {
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		b.Logger().Warn("error rolling back token batch", "error", err)
+		return
+	}
+
+	for i, token := range tokens {
+		if token == nil {
+			continue
+		}
+
+		if err := deleteToken(ctx, client, token.TokenID); err != nil && !errors.Is(err, errNotFound) {
+			b.Logger().Warn("error rolling back token of failed batch", "token_id", token.TokenID, "error", err)
+			continue
+		}
+
+		if err := s.Delete(ctx, getTokenPath(token.TokenID)); err != nil {
+			b.Logger().Warn("error removing token of failed batch from storage", "token_id", token.TokenID, "error", err)
+		}
+
+		b.deleteWAL(ctx, s, walIDs[i])
+	}
+}
@@ -0,0 +1,20 @@
+package jenkinssecretsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenBatchNames tests rendering token names from a name template
+func TestTokenBatchNames(t *testing.T) {
+	names, err := tokenBatchNames("runner-{{index}}", 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"runner-1", "runner-2", "runner-3"}, names)
+
+	_, err = tokenBatchNames("runner", 3)
+	require.Error(t, err)
+
+	_, err = tokenBatchNames("runner/{{index}}", 3)
+	require.Error(t, err)
+}
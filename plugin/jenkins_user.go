@@ -2,25 +2,57 @@ package jenkinssecretsengine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	jenkinsUserType = "jenkins_user"
+	// jenkinsUserDescription marks the Jenkins users created by the plugin
+	jenkinsUserDescription = "Managed by Vault"
+	// userExpiryCheckInterval is how often users are checked for expiry
+	userExpiryCheckInterval = 5 * time.Minute
+	// userLeaseIDLength is the length of the ID tying a user in the inventory to its lease
+	userLeaseIDLength = 20
 )
 
 // jenkinsUser defines a user as secret
 type jenkinsUser struct {
-	Username string        `json:"username"`
-	Password string        `json:"password,omitempty"`
-	Fullname string        `json:"fullname"`
-	Email    string        `json:"email"`
-	TTL      time.Duration `json:"ttl"`
-	MaxTTL   time.Duration `json:"max_ttl"`
+	CreationTime        time.Time     `json:"creation_time,omitempty"`
+	ExpiresAt           time.Time     `json:"expires_at,omitempty"`
+	RevokedAt           time.Time     `json:"revoked_at,omitempty"`
+	Username            string        `json:"username"`
+	Password            string        `json:"password,omitempty"`
+	Fullname            string        `json:"fullname"`
+	Email               string        `json:"email"`
+	EntityID            string        `json:"entity_id,omitempty"`
+	RoleName            string        `json:"role_name,omitempty"`
+	RevokeAction        string        `json:"revoke_action,omitempty"`
+	LeaseID             string        `json:"lease_id,omitempty"`
+	OriginalDescription string        `json:"original_description,omitempty"`
+	SSHPublicKeys       []string      `json:"ssh_public_keys,omitempty"`
+	TTL                 time.Duration `json:"ttl"`
+	MaxTTL              time.Duration `json:"max_ttl"`
+	Imported            bool          `json:"imported,omitempty"`
+	DeleteOnRevoke      bool          `json:"delete_on_revoke,omitempty"`
+}
+
+// foldUsername returns the form of a username Jenkins compares, as it
+// compares usernames case insensitively by default
+func foldUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+// sameUsername returns whether two usernames name the same Jenkins user
+func sameUsername(a, b string) bool {
+	return foldUsername(a) == foldUsername(b)
 }
 
 // toResponseData returns response data for a user
@@ -30,9 +62,77 @@ func (user *jenkinsUser) toResponseData() map[string]interface{} {
 		"fullname": user.Fullname,
 		"email":    user.Email,
 	}
+
+	// Imported users have no lease, their owner and expiry are kept in the inventory
+	if user.EntityID != "" {
+		respData["entity_id"] = user.EntityID
+	}
+	if !user.ExpiresAt.IsZero() {
+		respData["expires_at"] = user.ExpiresAt.Format(time.RFC3339)
+	}
+	if user.RoleName != "" {
+		respData["role_name"] = user.RoleName
+	}
+	if len(user.SSHPublicKeys) > 0 {
+		respData["ssh_public_keys"] = user.SSHPublicKeys
+	}
+
+	// Locked and disabled users stay in the inventory for audit
+	if !user.RevokedAt.IsZero() {
+		respData["revoke_action"] = user.RevokeAction
+		respData["revoked_at"] = user.RevokedAt.Format(time.RFC3339)
+	}
+
 	return respData
 }
 
+// toKeyInfo returns the key_info of a user when listing the inventory
+func (user *jenkinsUser) toKeyInfo() map[string]interface{} {
+	keyInfo := map[string]interface{}{
+		"fullname":      user.Fullname,
+		"email":         user.Email,
+		"creation_time": user.CreationTime,
+		"ttl":           int64(user.TTL.Seconds()),
+		"max_ttl":       int64(user.MaxTTL.Seconds()),
+		"entity_id":     user.EntityID,
+	}
+
+	// Users with a lease expire with it, only imported users have an expiry
+	if !user.ExpiresAt.IsZero() {
+		keyInfo["expires_at"] = user.ExpiresAt
+	}
+	if user.RoleName != "" {
+		keyInfo["role_name"] = user.RoleName
+	}
+	if !user.RevokedAt.IsZero() {
+		keyInfo["revoke_action"] = user.RevokeAction
+		keyInfo["revoked_at"] = user.RevokedAt
+	}
+	return keyInfo
+}
+
+// drift returns the attributes of a user that differ between the inventory and Jenkins,
+// keyed by attribute, with both values
+func (user *jenkinsUser) drift(info *jenkinsUserInfo) map[string]interface{} {
+	drift := map[string]interface{}{}
+
+	if user.Fullname != info.Fullname {
+		drift["fullname"] = map[string]interface{}{
+			"inventory": user.Fullname,
+			"jenkins":   info.Fullname,
+		}
+	}
+
+	if user.Email != info.Email {
+		drift["email"] = map[string]interface{}{
+			"inventory": user.Email,
+			"jenkins":   info.Email,
+		}
+	}
+
+	return drift
+}
+
 // jenkinsUser defines an a user in jenkins
 // and how it should be revoked or renewed.
 func (b *jenkinsBackend) jenkinsUser() *framework.Secret {
@@ -49,59 +149,198 @@ func (b *jenkinsBackend) jenkinsUser() *framework.Secret {
 	}
 }
 
-// userRevoke removes the user from the Vault storage API and calls the client to revoke the user
+// userRevoke removes the users of the lease from the Vault storage API and calls the client to
+// revoke them. A user deleted or revoked under /users since, or created again by another lease,
+// is left alone, and every user is revoked on its own so one failing doesn't keep the others.
 func (b *jenkinsBackend) userRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("error getting client: %w", err)
 	}
 
-	username := ""
-	usernameRaw, ok := req.Secret.InternalData["username"]
-	if ok {
-		username, ok = usernameRaw.(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid value for username in secret internal data")
+	data, err := decodeLeaseInternalData(req.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var merr *multierror.Error
+	for _, username := range data.usernames() {
+		user, err := b.getUserFromStorage(ctx, req.Storage, username)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("user %q: %w", username, err))
+			continue
+		}
+
+		if !user.belongsToLease(data.LeaseID) || !user.RevokedAt.IsZero() {
+			b.Logger().Warn("user is no longer managed by the lease, skipping revocation", "username", username)
+			continue
+		}
+
+		if err := b.revokeUser(ctx, req.Storage, client, username); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("user %q: %w", username, err))
+		}
+	}
+
+	return nil, merr.ErrorOrNil()
+}
+
+// revokeUser applies the revoke action of the config to a user. Deleted users are removed
+// from the inventory, locked and disabled ones stay in it with the action recorded, and
+// imported ones get their original description back as Vault no longer manages them.
+// Imported users are only unlinked from Vault unless they were imported with delete_on_revoke.
+func (b *jenkinsBackend) revokeUser(ctx context.Context, s logical.Storage, client *jenkinsClient, username string) error {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config != nil && config.isProtectedUser(username) {
+		return fmt.Errorf("user %q is protected and can not be revoked", username)
+	}
+
+	user, err := b.getUserFromStorage(ctx, s, username)
+	if err != nil {
+		return err
+	}
+
+	if user != nil && user.Imported && !user.DeleteOnRevoke {
+		return b.unlinkUser(ctx, s, client, user)
+	}
+
+	action := config.revokeAction()
+	if action == revokeActionDelete {
+		return b.removeUser(ctx, s, client, username)
+	}
+
+	switch action {
+	case revokeActionDisable:
+		var disabled bool
+		disabled, err = client.disableUser(ctx, username)
+		if err == nil && !disabled {
+			b.Logger().Warn("security realm can't disable users, locking user instead", "username", username)
+			action = revokeActionLock
+			err = b.lockUser(ctx, client, username)
 		}
+	case revokeActionLock:
+		err = b.lockUser(ctx, client, username)
+	}
+
+	// A user removed out-of-band is already revoked
+	if errors.Is(err, errNotFound) {
+		return b.removeUser(ctx, s, client, username)
+	} else if err != nil {
+		return fmt.Errorf("error revoking user: %w", err)
+	}
+
+	if user == nil {
+		return nil
+	}
+
+	if user.Imported {
+		if err := client.setUserDescription(ctx, username, user.OriginalDescription); err != nil {
+			return fmt.Errorf("error restoring user description: %w", err)
+		}
+	}
+
+	user.RevokeAction = action
+	user.RevokedAt = time.Now()
+
+	return b.putUser(ctx, s, user)
+}
+
+// unlinkUser gives an imported user its original description back and removes it from the
+// inventory, leaving the account in Jenkins as it was before the import
+func (b *jenkinsBackend) unlinkUser(ctx context.Context, s logical.Storage, client *jenkinsClient, user *jenkinsUser) error {
+	err := client.setUserDescription(ctx, user.Username, user.OriginalDescription)
+	if errors.Is(err, errNotFound) {
+		b.Logger().Warn("imported user was already deleted from Jenkins", "username", user.Username)
+	} else if err != nil {
+		return fmt.Errorf("error restoring user description: %w", err)
 	}
 
-	// Delete from Jenkins
-	if err := deleteUser(ctx, client, username); err != nil {
-		return nil, fmt.Errorf("error revoking user: %w", err)
+	if err := s.Delete(ctx, b.getUserPath(user.Username)); err != nil {
+		return fmt.Errorf("error remove user from storage: %w", err)
 	}
 
-	// Delete from store
-	err = req.Storage.Delete(ctx, b.getUserPath(username))
+	return nil
+}
+
+// lockUser locks a user out of Jenkins with a generated password that is not kept
+func (b *jenkinsBackend) lockUser(ctx context.Context, client *jenkinsClient, username string) error {
+	password, err := generatePassword()
 	if err != nil {
-		return nil, fmt.Errorf("error remove user from storage: %w", err)
+		return err
 	}
 
-	return nil, nil
+	return client.lockUser(ctx, username, password)
 }
 
-// userRenew renews the ttl time in vault
+// removeUser deletes a user from Jenkins, a user removed out-of-band is already
+// revoked, and removes it from the inventory
+func (b *jenkinsBackend) removeUser(ctx context.Context, s logical.Storage, client *jenkinsClient, username string) error {
+	err := deleteUser(ctx, client, username)
+	if errors.Is(err, errNotFound) {
+		b.Logger().Warn("user was already deleted from Jenkins", "username", username)
+	} else if err != nil {
+		return fmt.Errorf("error revoking user: %w", err)
+	}
+
+	if err := s.Delete(ctx, b.getUserPath(username)); err != nil {
+		return fmt.Errorf("error remove user from storage: %w", err)
+	}
+
+	return nil
+}
+
+// userRenew renews the ttl time in vault as long as every user of the lease still exists in Jenkins
 func (b *jenkinsBackend) userRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	ttlRaw, ok := req.Secret.InternalData["ttl"]
-	if !ok {
-		return nil, fmt.Errorf("secret is missing ttl internal data")
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
 	}
-	maxTtlRaw, ok := req.Secret.InternalData["max_ttl"]
-	if !ok {
-		return nil, fmt.Errorf("secret is missing max_ttl internal data")
+
+	data, err := decodeLeaseInternalData(req.Secret)
+	if err != nil {
+		return nil, err
 	}
 
-	resp := &logical.Response{Secret: req.Secret}
-	ttl := time.Duration(ttlRaw.(float64)) * time.Second
-	maxTtl := time.Duration(maxTtlRaw.(float64)) * time.Second
+	for _, username := range data.usernames() {
+		entry, err := b.getUserFromStorage(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
 
-	if ttl > 0 {
-		resp.Secret.TTL = ttl
+		if !entry.belongsToLease(data.LeaseID) || !entry.RevokedAt.IsZero() {
+			return nil, fmt.Errorf("user %q is no longer managed by this lease", username)
+		}
+
+		user, err := client.getUser(ctx, username)
+		if err != nil {
+			return nil, fmt.Errorf("error checking user: %w", err)
+		}
+
+		if user == nil {
+			return nil, fmt.Errorf("user %q no longer exists in Jenkins", username)
+		}
 	}
-	if maxTtl > 0 {
-		resp.Secret.MaxTTL = maxTtl
+
+	return renewResponse(req, data), nil
+}
+
+// belongsToLease returns whether an inventory entry is the user created by the lease of
+// the given ID. Leases issued before lease IDs were recorded have an empty one.
+func (user *jenkinsUser) belongsToLease(leaseID string) bool {
+	return user != nil && user.LeaseID == leaseID
+}
+
+// newUserLeaseID returns a random ID tying a user to its lease
+func newUserLeaseID() (string, error) {
+	leaseID, err := base62.Random(userLeaseIDLength)
+	if err != nil {
+		return "", fmt.Errorf("error generating lease ID: %w", err)
 	}
 
-	return resp, nil
+	return leaseID, nil
 }
 
 // createUser calls the jenkins client to create and return a new user
@@ -111,6 +350,14 @@ func createUser(ctx context.Context, j *jenkinsClient, username, password, fulln
 		return nil, fmt.Errorf("error creating jenkins user: %w", err)
 	}
 
+	if err := j.setUserDescription(ctx, username, jenkinsUserDescription); err != nil {
+		// Without the marker tidy could never find the user, so don't leave it behind
+		if deleteErr := deleteUser(ctx, j, username); deleteErr != nil {
+			return nil, fmt.Errorf("error marking jenkins user: %v, error removing user: %w", err, deleteErr)
+		}
+		return nil, fmt.Errorf("error marking jenkins user: %w", err)
+	}
+
 	return &jenkinsUser{
 		Username: user.UserName,
 		Password: password,
@@ -119,9 +366,97 @@ func createUser(ctx context.Context, j *jenkinsClient, username, password, fulln
 	}, nil
 }
 
+// importUser writes an existing Jenkins user into the inventory and marks it, so it is
+// managed like a user created by Vault. Imported users have no lease, so they expire
+// at expiresAt instead when it is set. Their description is kept to be restored on revoke.
+// Revoking them only unlinks them from Vault unless deleteOnRevoke is set.
+func (b *jenkinsBackend) importUser(ctx context.Context, s logical.Storage, j *jenkinsClient, info *jenkinsUserInfo, entityID string, expiresAt time.Time, deleteOnRevoke bool) (*jenkinsUser, error) {
+	user := &jenkinsUser{
+		CreationTime:        time.Now(),
+		Username:            info.ID,
+		Fullname:            info.Fullname,
+		Email:               info.Email,
+		EntityID:            entityID,
+		ExpiresAt:           expiresAt,
+		OriginalDescription: info.Description,
+		Imported:            true,
+		DeleteOnRevoke:      deleteOnRevoke,
+	}
+
+	// Write the inventory first, tidy deletes marked users missing from it
+	entry, err := logical.StorageEntryJSON(b.getUserPath(user.Username), user)
+	if err != nil {
+		return nil, fmt.Errorf("error creating user storage entry: %w", err)
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("error writing user to internal storage: %w", err)
+	}
+
+	if err := j.setUserDescription(ctx, user.Username, jenkinsUserDescription); err != nil {
+		return nil, fmt.Errorf("error marking jenkins user: %w", err)
+	}
+
+	return user, nil
+}
+
+// expireUsers revokes imported users, which have no lease, once they expire
+func (b *jenkinsBackend) expireUsers(ctx context.Context, s logical.Storage) error {
+	if time.Since(b.lastUserExpiryCheck) < userExpiryCheckInterval {
+		return nil
+	}
+
+	b.lastUserExpiryCheck = time.Now()
+
+	usernames, err := s.List(ctx, fmt.Sprintf("%s/", usersPrefix))
+	if err != nil {
+		return err
+	}
+
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var client *jenkinsClient
+	var merr *multierror.Error
+	for _, username := range usernames {
+		user, err := b.getUserFromStorage(ctx, s, username)
+		if err != nil {
+			return err
+		}
+
+		if user == nil || user.ExpiresAt.IsZero() || time.Now().Before(user.ExpiresAt) || !user.RevokedAt.IsZero() {
+			continue
+		}
+
+		// A user protected after it was imported stays untouched
+		if config != nil && config.isProtectedUser(username) {
+			b.Logger().Warn("skipping expired protected user", "username", username)
+			continue
+		}
+
+		if client == nil {
+			client, err = b.getClient(ctx, s)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := b.revokeUser(ctx, s, client, username); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("user %q: %w", username, err))
+			continue
+		}
+
+		b.Logger().Info("revoked expired user", "username", username, "entity_id", user.EntityID, "expires_at", user.ExpiresAt)
+	}
+
+	return merr.ErrorOrNil()
+}
+
 // deleteUser revokes the user
 func deleteUser(ctx context.Context, j *jenkinsClient, username string) error {
-	err := j.DeleteUser(ctx, username)
+	err := j.deleteUser(ctx, username)
 	if err != nil {
 		return err
 	}
@@ -152,6 +487,20 @@ func (b *jenkinsBackend) getUserFromStorage(ctx context.Context, s logical.Stora
 	return &user, nil
 }
 
+// putUser writes a user to the inventory
+func (b *jenkinsBackend) putUser(ctx context.Context, s logical.Storage, user *jenkinsUser) error {
+	entry, err := logical.StorageEntryJSON(b.getUserPath(user.Username), user)
+	if err != nil {
+		return fmt.Errorf("error creating user storage entry: %w", err)
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return fmt.Errorf("error writing user to internal storage: %w", err)
+	}
+
+	return nil
+}
+
 // getUserPath returns the user storage path such as /users/user
 func (b *jenkinsBackend) getUserPath(username string) string {
 	return fmt.Sprintf("%s/%s", usersPrefix, username)
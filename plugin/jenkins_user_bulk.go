@@ -0,0 +1,269 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// maxUserBulkCount is the largest number of users created by a single request
+	maxUserBulkCount = 100
+	// userBulkConcurrency is how many users of a bulk request are created in parallel
+	userBulkConcurrency = 5
+)
+
+// usernameRegex matches the usernames accepted under /users
+var usernameRegex = regexp.MustCompile(fmt.Sprintf("^%s$", framework.GenericNameRegex("name")))
+
+// errUserBulkEntryTTL is returned for a ttl set on a single user of a bulk request
+var errUserBulkEntryTTL = errors.New("ttl can not be set per user, the users of a request share its lease")
+
+// userBulkEntry is a user to create in a bulk request
+type userBulkEntry struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Fullname string `mapstructure:"fullname"`
+	Email    string `mapstructure:"email"`
+}
+
+// parseUserBulkJSON decodes the users of a bulk request given as a list of objects
+func parseUserBulkJSON(raw []interface{}) ([]*userBulkEntry, error) {
+	entries := make([]*userBulkEntry, 0, len(raw))
+	for i, item := range raw {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("user %d is not an object", i+1)
+		}
+
+		entry := &userBulkEntry{}
+		if err := mapstructure.WeakDecode(fields, entry); err != nil {
+			return nil, fmt.Errorf("user %d: %w", i+1, err)
+		}
+
+		if _, ok := fields["ttl"]; ok {
+			return nil, fmt.Errorf("user %d: %w", i+1, errUserBulkEntryTTL)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// parseUserBulkCSV decodes the users of a bulk request given as CSV text. The first
+// line is a header naming the columns among username, password, fullname and email.
+func parseUserBulkCSV(text string) ([]*userBulkEntry, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "ttl" {
+			return nil, errUserBulkEntryTTL
+		}
+		if !containsString([]string{"username", "password", "fullname", "email"}, column) {
+			return nil, fmt.Errorf("unknown csv column %q", column)
+		}
+		columns[column] = i
+	}
+
+	if _, ok := columns["username"]; !ok {
+		return nil, errors.New("csv header is missing the username column")
+	}
+
+	value := func(record []string, column string) string {
+		if i, ok := columns[column]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	entries := []*userBulkEntry{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv: %w", err)
+		}
+
+		entry := &userBulkEntry{
+			Username: value(record, "username"),
+			Password: value(record, "password"),
+			Fullname: value(record, "fullname"),
+			Email:    value(record, "email"),
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// validateUserBulkEntries ensures every entry of a bulk request has a valid and unique username
+func validateUserBulkEntries(entries []*userBulkEntry) error {
+	if len(entries) < 1 || len(entries) > maxUserBulkCount {
+		return fmt.Errorf("between 1 and %d users must be given", maxUserBulkCount)
+	}
+
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		if !usernameRegex.MatchString(entry.Username) {
+			return fmt.Errorf("user %d: invalid username %q", i+1, entry.Username)
+		}
+
+		if seen[foldUsername(entry.Username)] {
+			return fmt.Errorf("user %d: duplicate username %q", i+1, entry.Username)
+		}
+		seen[foldUsername(entry.Username)] = true
+	}
+
+	return nil
+}
+
+// createUserBulk creates a user for each entry with bounded parallelism. It returns
+// the users created, without the ones that failed, the IDs of the WAL entries of the
+// users created, and an error per failed entry.
+func (b *jenkinsBackend) createUserBulk(ctx context.Context, req *logical.Request, config *jenkinsConfig, lease *leaseInternalData, entries []*userBulkEntry) ([]*jenkinsUser, []string, []error) {
+	users := make([]*jenkinsUser, len(entries))
+	walIDs := make([]string, len(entries))
+	errs := make([]error, len(entries))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, userBulkConcurrency)
+	for i, entry := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, entry *userBulkEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			users[i], walIDs[i], errs[i] = b.createBulkUser(ctx, req, config, lease, entry)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	return users, walIDs, errs
+}
+
+// createBulkUser creates the user of a single bulk entry and writes it into the inventory,
+// tied to the lease of the request. The WAL entry of the user is returned rather than
+// deleted, as the request may still roll the user back.
+func (b *jenkinsBackend) createBulkUser(ctx context.Context, req *logical.Request, config *jenkinsConfig, lease *leaseInternalData, entry *userBulkEntry) (*jenkinsUser, string, error) {
+	if config.isProtectedUser(entry.Username) {
+		return nil, "", errors.New("user is protected and can not be created")
+	}
+
+	if err := config.UserPolicy.validate(entry.Username, entry.Email); err != nil {
+		return nil, "", err
+	}
+
+	existing, err := b.getUserFromStorage(ctx, req.Storage, entry.Username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if existing != nil {
+		return nil, "", errors.New("user is already managed by Vault")
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info, err := client.getUser(ctx, entry.Username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if info != nil {
+		return nil, "", errors.New("user already exists in Jenkins")
+	}
+
+	password := entry.Password
+	if password == "" {
+		if password, err = generatePassword(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	walID, err := putUserWAL(ctx, req.Storage, entry.Username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err := b.createUser(ctx, req.Storage, jenkinsUser{
+		Username: entry.Username,
+		Password: password,
+		Fullname: entry.Fullname,
+		Email:    entry.Email,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	inventory := *user
+	inventory.Password = ""
+	inventory.TTL = lease.ttl()
+	inventory.MaxTTL = lease.maxTTL()
+	inventory.EntityID = req.EntityID
+	inventory.LeaseID = lease.LeaseID
+	inventory.CreationTime = time.Now()
+
+	if err := b.putUser(ctx, req.Storage, &inventory); err != nil {
+		return nil, "", err
+	}
+
+	return user, walID, nil
+}
+
+// rollbackUserBulk deletes the users of a bulk request that failed from the inventory and
+// Jenkins. The WAL entry of a user is only deleted once the user is gone from Jenkins, so
+// the WAL rollback retries the others.
+func (b *jenkinsBackend) rollbackUserBulk(ctx context.Context, s logical.Storage, users []*jenkinsUser, walIDs []string) error {
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	for i, user := range users {
+		if user == nil {
+			continue
+		}
+
+		if err := s.Delete(ctx, b.getUserPath(user.Username)); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("user %q: %w", user.Username, err))
+			continue
+		}
+
+		if err := deleteUser(ctx, client, user.Username); err != nil && !errors.Is(err, errNotFound) {
+			merr = multierror.Append(merr, fmt.Errorf("user %q: %w", user.Username, err))
+			continue
+		}
+
+		b.deleteWAL(ctx, s, walIDs[i])
+	}
+
+	return merr.ErrorOrNil()
+}
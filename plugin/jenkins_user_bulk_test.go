@@ -0,0 +1,49 @@
+package jenkinssecretsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseUserBulk tests decoding the users of a bulk request from a list or CSV text
+func TestParseUserBulk(t *testing.T) {
+	entries, err := parseUserBulkJSON([]interface{}{
+		map[string]interface{}{"username": "trainee-1", "fullname": "Trainee One", "email": "one@example.com"},
+		map[string]interface{}{"username": "trainee-2", "password": "secret"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*userBulkEntry{
+		{Username: "trainee-1", Fullname: "Trainee One", Email: "one@example.com"},
+		{Username: "trainee-2", Password: "secret"},
+	}, entries)
+	require.NoError(t, validateUserBulkEntries(entries))
+
+	entries, err = parseUserBulkCSV("username, email\ntrainee-1, one@example.com\ntrainee-2,\n")
+	require.NoError(t, err)
+	require.Equal(t, []*userBulkEntry{
+		{Username: "trainee-1", Email: "one@example.com"},
+		{Username: "trainee-2"},
+	}, entries)
+	require.NoError(t, validateUserBulkEntries(entries))
+
+	_, err = parseUserBulkCSV("fullname,email\nTrainee,one@example.com\n")
+	require.Error(t, err)
+
+	_, err = parseUserBulkCSV("username,role\ntrainee-1,admin\n")
+	require.Error(t, err)
+
+	_, err = parseUserBulkJSON([]interface{}{"trainee-1"})
+	require.Error(t, err)
+
+	// Users of a request share its lease, so they can't have a ttl of their own
+	_, err = parseUserBulkJSON([]interface{}{map[string]interface{}{"username": "trainee-1", "ttl": "2h"}})
+	require.ErrorIs(t, err, errUserBulkEntryTTL)
+
+	_, err = parseUserBulkCSV("username,ttl\ntrainee-1,2h\n")
+	require.ErrorIs(t, err, errUserBulkEntryTTL)
+
+	require.Error(t, validateUserBulkEntries(nil))
+	require.Error(t, validateUserBulkEntries([]*userBulkEntry{{Username: "trainee/1"}}))
+	require.Error(t, validateUserBulkEntries([]*userBulkEntry{{Username: "trainee"}, {Username: "Trainee"}}))
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -11,15 +13,61 @@ import (
 
 const (
 	configPrefix = "config"
+
+	defaultTidySafetyBuffer = time.Hour
+)
+
+// What happens to a Jenkins user when its lease is revoked
+const (
+	revokeActionDelete  = "delete"
+	revokeActionLock    = "lock"
+	revokeActionDisable = "disable"
 )
 
+// revokeActions are the supported values of revoke_action
+var revokeActions = []string{revokeActionDelete, revokeActionLock, revokeActionDisable}
+
 // jenkinsConfig includes the minimum configuration
 // required to instantiate a new jenkins client.
 type jenkinsConfig struct {
-	Username       string `json:"username"`
-	Password       string `json:"password"`
-	URL            string `json:"url"`
-	ValidateClient bool   `json:"validate,omitempty"`
+	Username            string        `json:"username"`
+	Password            string        `json:"password"`
+	URL                 string        `json:"url"`
+	RevokeAction        string        `json:"revoke_action"`
+	TidyInterval        time.Duration `json:"tidy_interval"`
+	TidySafetyBuffer    time.Duration `json:"tidy_safety_buffer"`
+	UserPolicy          userPolicy    `json:"user_policy"`
+	ProtectedUsers      []string      `json:"protected_users"`
+	IdleTimeout         time.Duration `json:"idle_timeout"`
+	ValidateClient      bool          `json:"validate,omitempty"`
+	AllowOnBehalfTokens bool          `json:"allow_on_behalf_tokens"`
+	OwnerOnly           bool          `json:"owner_only"`
+}
+
+// isProtectedUser returns whether a Jenkins user must never be created, deleted or
+// rotated by the plugin
+func (config *jenkinsConfig) isProtectedUser(username string) bool {
+	if sameUsername(username, config.Username) {
+		return true
+	}
+
+	for _, protected := range config.ProtectedUsers {
+		if sameUsername(username, protected) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// revokeAction returns what happens to a Jenkins user when it is revoked.
+// Configs written before the setting existed delete users.
+func (config *jenkinsConfig) revokeAction() string {
+	if config == nil || config.RevokeAction == "" {
+		return revokeActionDelete
+	}
+
+	return config.RevokeAction
 }
 
 // pathConfig extends the Vault API with a `/config`
@@ -29,43 +77,86 @@ type jenkinsConfig struct {
 // is marked as sensitive and will not be output
 // when you read the configuration.
 func pathConfig(b *jenkinsBackend) *framework.Path {
-	return &framework.Path{
-		Pattern: configPrefix,
-		Fields: map[string]*framework.FieldSchema{
-			"username": {
-				Type:        framework.TypeString,
-				Description: "The username to access Jenkins",
-				Required:    true,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:      "Username",
-					Sensitive: false,
-				},
-			},
-			"password": {
-				Type:        framework.TypeString,
-				Description: "The user's password to access Jenkins",
-				Required:    true,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:      "Password",
-					Sensitive: true,
-				},
+	fields := map[string]*framework.FieldSchema{
+		"username": {
+			Type:        framework.TypeString,
+			Description: "The username to access Jenkins",
+			Required:    true,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:      "Username",
+				Sensitive: false,
 			},
-			"url": {
-				Type:        framework.TypeString,
-				Description: "The Jenkins URL",
-				Required:    true,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:      "URL",
-					Sensitive: false,
-				},
+		},
+		"password": {
+			Type:        framework.TypeString,
+			Description: "The user's password to access Jenkins",
+			Required:    true,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:      "Password",
+				Sensitive: true,
 			},
-			"validate": {
-				Type:        framework.TypeBool,
-				Description: fmt.Sprintf("The ensure jenkins client can connect and authenticate on init when writing to /%s mount", configPrefix),
-				Required:    false,
-				Default:     true,
+		},
+		"url": {
+			Type:        framework.TypeString,
+			Description: "The Jenkins URL",
+			Required:    true,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:      "URL",
+				Sensitive: false,
 			},
 		},
+		"validate": {
+			Type:        framework.TypeBool,
+			Description: fmt.Sprintf("The ensure jenkins client can connect and authenticate on init when writing to /%s mount", configPrefix),
+			Required:    false,
+			Default:     true,
+		},
+		"tidy_interval": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How often orphaned tokens and users are tidied automatically. If not set or set to 0, automatic tidy is disabled.",
+			Required:    false,
+		},
+		"tidy_safety_buffer": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How long a token or user must have been orphaned before tidy removes it. Defaults to 1h and can't be less than 10m, so requests still in flight are left to roll back.",
+			Required:    false,
+			Default:     int(defaultTidySafetyBuffer.Seconds()),
+		},
+		"idle_timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How long a token issued under /tokens may go unused before it is revoked. If not set or set to 0, idle tokens are not revoked.",
+			Required:    false,
+		},
+		"protected_users": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Jenkins users that can never be created, deleted or rotated by the plugin. The configured user is always protected.",
+			Required:    false,
+		},
+		"owner_only": {
+			Type:        framework.TypeBool,
+			Description: fmt.Sprintf("Only allow the entity that created a user to read, update, rotate or delete it under /%s. Other entities need access to /%s/%s. Defaults to false.", usersPrefix, managePrefix, usersPrefix),
+			Required:    false,
+		},
+		"revoke_action": {
+			Type:        framework.TypeString,
+			Description: fmt.Sprintf("What happens to a user when it is revoked. Supported values: %s. Defaults to delete.", strings.Join(revokeActions, ", ")),
+			Required:    false,
+		},
+		"allow_on_behalf_tokens": {
+			Type:        framework.TypeBool,
+			Description: "Allow roles to issue API tokens for other Jenkins users through the script console. Defaults to false.",
+			Required:    false,
+		},
+	}
+
+	// The user policy applies to users created without a role
+	for name, field := range userPolicyFields() {
+		fields[name] = field
+	}
+
+	return &framework.Path{
+		Pattern: configPrefix,
+		Fields:  fields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.pathConfigRead,
@@ -103,11 +194,21 @@ func (b *jenkinsBackend) pathConfigRead(ctx context.Context, req *logical.Reques
 		return nil, err
 	}
 
+	respData := map[string]interface{}{
+		"username":               config.Username,
+		"url":                    config.URL,
+		"tidy_interval":          int64(config.TidyInterval.Seconds()),
+		"tidy_safety_buffer":     int64(config.TidySafetyBuffer.Seconds()),
+		"idle_timeout":           int64(config.IdleTimeout.Seconds()),
+		"allow_on_behalf_tokens": config.AllowOnBehalfTokens,
+		"protected_users":        config.ProtectedUsers,
+		"revoke_action":          config.revokeAction(),
+		"owner_only":             config.OwnerOnly,
+	}
+	config.UserPolicy.addResponseData(respData)
+
 	return &logical.Response{
-		Data: map[string]interface{}{
-			"username": config.Username,
-			"url":      config.URL,
-		},
+		Data: respData,
 	}, nil
 }
 
@@ -145,6 +246,48 @@ func (b *jenkinsBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		return nil, fmt.Errorf("missing password in configuration")
 	}
 
+	if tidyInterval, ok := data.GetOk("tidy_interval"); ok {
+		config.TidyInterval = time.Duration(tidyInterval.(int)) * time.Second
+	}
+
+	if tidySafetyBuffer, ok := data.GetOk("tidy_safety_buffer"); ok {
+		config.TidySafetyBuffer = time.Duration(tidySafetyBuffer.(int)) * time.Second
+	} else if createOperation {
+		config.TidySafetyBuffer = time.Duration(data.Get("tidy_safety_buffer").(int)) * time.Second
+	}
+
+	// Tidy must not race the WAL rollback of requests that haven't completed yet
+	if config.TidySafetyBuffer < walRollbackMinAge {
+		return logical.ErrorResponse("tidy_safety_buffer can't be less than %s", walRollbackMinAge), nil
+	}
+
+	if idleTimeout, ok := data.GetOk("idle_timeout"); ok {
+		config.IdleTimeout = time.Duration(idleTimeout.(int)) * time.Second
+	}
+
+	if protectedUsers, ok := data.GetOk("protected_users"); ok {
+		config.ProtectedUsers = protectedUsers.([]string)
+	}
+
+	if allowOnBehalfTokens, ok := data.GetOk("allow_on_behalf_tokens"); ok {
+		config.AllowOnBehalfTokens = allowOnBehalfTokens.(bool)
+	}
+
+	if ownerOnly, ok := data.GetOk("owner_only"); ok {
+		config.OwnerOnly = ownerOnly.(bool)
+	}
+
+	if revokeAction, ok := data.GetOk("revoke_action"); ok {
+		if !containsString(revokeActions, revokeAction.(string)) {
+			return logical.ErrorResponse("unsupported revoke_action %q, must be one of: %s", revokeAction, strings.Join(revokeActions, ", ")), nil
+		}
+		config.RevokeAction = revokeAction.(string)
+	}
+
+	if err := config.UserPolicy.update(data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	entry, err := logical.StorageEntryJSON(configPrefix, config)
 	if err != nil {
 		return nil, err
@@ -3,6 +3,7 @@ package jenkinssecretsengine
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
@@ -23,25 +24,61 @@ func TestConfig(t *testing.T) {
 		assert.NoError(t, err)
 
 		err = testConfigRead(t, b, reqStorage, map[string]interface{}{
-			"username": testUsername,
-			"url":      testURL,
+			"username":               testUsername,
+			"url":                    testURL,
+			"tidy_interval":          int64(0),
+			"tidy_safety_buffer":     int64(3600),
+			"idle_timeout":           int64(0),
+			"allow_on_behalf_tokens": false,
+			"protected_users":        []string(nil),
+			"allowed_email_domains":  []string(nil),
+			"username_regex":         "",
+			"reserved_usernames":     []string(nil),
+			"username_prefix":        "",
+			"revoke_action":          "delete",
+			"owner_only":             false,
 		})
 		assert.NoError(t, err)
 
 		// Ensure we can update
 		err = testConfigUpdate(t, b, reqStorage, map[string]interface{}{
-			"username": testUsername,
-			"url":      "http://localhost:8081",
-			"validate": false,
+			"username":              testUsername,
+			"url":                   "http://localhost:8081",
+			"tidy_interval":         "1h",
+			"protected_users":       "jenkins-ops",
+			"allowed_email_domains": "example.com",
+			"username_prefix":       "tmp-",
+			"revoke_action":         "lock",
+			"owner_only":            true,
+			"idle_timeout":          "24h",
+			"validate":              false,
 		})
 		assert.NoError(t, err)
 
 		err = testConfigRead(t, b, reqStorage, map[string]interface{}{
-			"username": testUsername,
-			"url":      "http://localhost:8081",
+			"username":               testUsername,
+			"url":                    "http://localhost:8081",
+			"tidy_interval":          int64(3600),
+			"tidy_safety_buffer":     int64(3600),
+			"idle_timeout":           int64(86400),
+			"allow_on_behalf_tokens": false,
+			"protected_users":        []string{"jenkins-ops"},
+			"allowed_email_domains":  []string{"example.com"},
+			"username_regex":         "",
+			"reserved_usernames":     []string(nil),
+			"username_prefix":        "tmp-",
+			"revoke_action":          "lock",
+			"owner_only":             true,
 		})
 		assert.NoError(t, err)
 
+		// Ensure tidy can't run before requests in flight are rolled back
+		err = testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+			"tidy_safety_buffer": "1m",
+			"validate":           false,
+		})
+		assert.Error(t, err)
+
 		// Ensure we can update and validation works
 		err = testConfigUpdate(t, b, reqStorage, map[string]interface{}{
 			"username": testUsername,
@@ -136,7 +173,7 @@ func testConfigRead(t *testing.T, b logical.Backend, s logical.Storage, expected
 
 		if !ok {
 			return fmt.Errorf(`expected data["%s"] = %v but was not included in read output"`, k, expectedV)
-		} else if expectedV != actualV {
+		} else if !reflect.DeepEqual(expectedV, actualV) {
 			return fmt.Errorf(`expected data["%s"] = %v, instead got %v"`, k, expectedV, actualV)
 		}
 	}
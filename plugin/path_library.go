@@ -0,0 +1,580 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const libraryPrefix = "library"
+
+// pathLibrary extends the Vault API with a `/library` endpoint
+// to check out existing Jenkins users exclusively.
+func pathLibrary(b *jenkinsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/manage/%s/check-in$", libraryPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set",
+					Required:    true,
+				},
+				"service_account_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Service accounts to check in",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryManageCheckIn,
+				},
+			},
+			HelpSynopsis:    pathLibraryManageCheckInHelpSyn,
+			HelpDescription: pathLibraryManageCheckInHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/check-out$", libraryPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set",
+					Required:    true,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Lease for the check-out. Can not exceed the ttl of the set.",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryCheckOut,
+				},
+			},
+			HelpSynopsis:    pathLibraryCheckOutHelpSyn,
+			HelpDescription: pathLibraryCheckOutHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/check-in$", libraryPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set",
+					Required:    true,
+				},
+				"service_account_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Service accounts to check in. Defaults to every account of the set checked out by the caller.",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryCheckIn,
+				},
+			},
+			HelpSynopsis:    pathLibraryCheckInHelpSyn,
+			HelpDescription: pathLibraryCheckInHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/status$", libraryPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathLibraryStatus,
+				},
+			},
+			HelpSynopsis:    pathLibraryStatusHelpSyn,
+			HelpDescription: pathLibraryStatusHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", libraryPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the set",
+					Required:    true,
+				},
+				"service_account_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Existing Jenkins users that can be checked out from the set",
+					Required:    true,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Default lease for a check-out. If not set or set to 0, will use system default.",
+					Required:    false,
+				},
+				"max_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Maximum time for a check-out. If not set or set to 0, will use system default.",
+					Required:    false,
+				},
+				"disable_check_in_enforcement": {
+					Type:        framework.TypeBool,
+					Description: "Allow any entity to check in accounts of the set, not only the one that checked them out",
+					Required:    false,
+					Default:     false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathLibraryRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathLibraryWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathLibraryDelete,
+				},
+			},
+			ExistenceCheck:  b.pathLibraryExistenceCheck,
+			HelpSynopsis:    pathLibraryHelpSyn,
+			HelpDescription: pathLibraryHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/?$", libraryPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathLibraryList,
+				},
+			},
+			HelpSynopsis:    pathLibraryListHelpSyn,
+			HelpDescription: pathLibraryListHelpDesc,
+		},
+	}
+}
+
+// pathLibraryExistenceCheck verifies if a library set exists.
+func (b *jenkinsBackend) pathLibraryExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, req.Path)
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %w", err)
+	}
+
+	return out != nil, nil
+}
+
+// pathLibraryList makes a request to Vault storage to retrieve a list of library sets for the backend
+func (b *jenkinsBackend) pathLibraryList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, fmt.Sprintf("%s/", libraryPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathLibraryRead returns a library set
+func (b *jenkinsBackend) pathLibraryRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	set, err := getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: set.toResponseData(),
+	}, nil
+}
+
+// pathLibraryWrite creates or updates a library set
+func (b *jenkinsBackend) pathLibraryWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.checkOutLock.Lock()
+	defer b.checkOutLock.Unlock()
+
+	name := d.Get("name").(string)
+	set, err := getLibrarySet(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	createOperation := (req.Operation == logical.CreateOperation)
+
+	if set == nil {
+		if !createOperation {
+			return nil, errors.New("library set not found during update operation")
+		}
+		set = &jenkinsLibrarySet{
+			Name: name,
+		}
+	}
+
+	if serviceAccountNames, ok := d.GetOk("service_account_names"); ok {
+		// Accounts can not be removed while someone is holding them
+		for _, username := range set.ServiceAccountNames {
+			if containsString(serviceAccountNames.([]string), username) {
+				continue
+			}
+			checkOut, err := getCheckOut(ctx, req.Storage, username)
+			if err != nil {
+				return nil, err
+			}
+			if checkOut != nil {
+				return logical.ErrorResponse("service account %q is checked out and can not be removed from the set", username), nil
+			}
+		}
+		set.ServiceAccountNames = serviceAccountNames.([]string)
+	} else if createOperation {
+		return logical.ErrorResponse("missing service_account_names"), nil
+	}
+
+	if len(set.ServiceAccountNames) == 0 {
+		return logical.ErrorResponse("service_account_names must contain at least one account"), nil
+	}
+
+	if ttl, ok := d.GetOk("ttl"); ok {
+		set.TTL = time.Duration(ttl.(int)) * time.Second
+	}
+
+	if maxTTL, ok := d.GetOk("max_ttl"); ok {
+		set.MaxTTL = time.Duration(maxTTL.(int)) * time.Second
+	}
+
+	if set.MaxTTL > 0 && set.TTL > set.MaxTTL {
+		return logical.ErrorResponse("ttl can not be greater than max_ttl"), nil
+	}
+
+	if disable, ok := d.GetOk("disable_check_in_enforcement"); ok {
+		set.DisableCheckInEnforcement = disable.(bool)
+	}
+
+	if resp, err := b.validateServiceAccounts(ctx, req.Storage, set); resp != nil || err != nil {
+		return resp, err
+	}
+
+	entry, err := logical.StorageEntryJSON(getLibrarySetPath(set.Name), set)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// validateServiceAccounts ensures the accounts of a set are not protected
+// users and do not belong to another set.
+func (b *jenkinsBackend) validateServiceAccounts(ctx context.Context, s logical.Storage, set *jenkinsLibrarySet) (*logical.Response, error) {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		for _, username := range set.ServiceAccountNames {
+			if config.isProtectedUser(username) {
+				return logical.ErrorResponse("user %q is protected and can not be checked out", username), nil
+			}
+		}
+	}
+
+	names, err := s.List(ctx, fmt.Sprintf("%s/", libraryPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if name == set.Name {
+			continue
+		}
+
+		other, err := getLibrarySet(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if other == nil {
+			continue
+		}
+
+		for _, username := range set.ServiceAccountNames {
+			if other.hasServiceAccount(username) {
+				return logical.ErrorResponse("service account %q already belongs to set %q", username, other.Name), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// pathLibraryDelete removes a library set once none of its accounts are checked out
+func (b *jenkinsBackend) pathLibraryDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.checkOutLock.Lock()
+	defer b.checkOutLock.Unlock()
+
+	set, err := getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	for _, username := range set.ServiceAccountNames {
+		checkOut, err := getCheckOut(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
+		if checkOut != nil {
+			return logical.ErrorResponse("service account %q is checked out, check it in before deleting the set", username), nil
+		}
+	}
+
+	err = req.Storage.Delete(ctx, getLibrarySetPath(set.Name))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting library set: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathLibraryCheckOut checks out an available account of the set with a new password
+func (b *jenkinsBackend) pathLibraryCheckOut(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.checkOutLock.Lock()
+	defer b.checkOutLock.Unlock()
+
+	set, err := getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return logical.ErrorResponse("unknown library set"), nil
+	}
+
+	ttl := set.TTL
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		ttl = time.Duration(ttlRaw.(int)) * time.Second
+		if set.TTL > 0 && ttl > set.TTL {
+			return logical.ErrorResponse("ttl can not be greater than the ttl of the set"), nil
+		}
+	}
+
+	checkOut, username, password, err := b.checkOut(ctx, req.Storage, set, req.EntityID)
+	if errors.Is(err, errNoAccountAvailable) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	respData := map[string]interface{}{
+		"username": username,
+		"password": password,
+	}
+
+	// Need to store the check out ID so that a stale lease can not check in a later check out
+	internalData := map[string]interface{}{
+		"username":     username,
+		"set_name":     set.Name,
+		"check_out_id": checkOut.CheckOutID,
+	}
+
+	resp := b.Secret(jenkinsLibraryCredsType).Response(respData, internalData)
+
+	if ttl > 0 {
+		resp.Secret.TTL = ttl
+	}
+	if set.MaxTTL > 0 {
+		resp.Secret.MaxTTL = set.MaxTTL
+	}
+
+	return resp, nil
+}
+
+// pathLibraryCheckIn checks in accounts held by the caller
+func (b *jenkinsBackend) pathLibraryCheckIn(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return b.checkInServiceAccounts(ctx, req, d, false)
+}
+
+// pathLibraryManageCheckIn checks in accounts regardless of who holds them
+func (b *jenkinsBackend) pathLibraryManageCheckIn(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return b.checkInServiceAccounts(ctx, req, d, true)
+}
+
+// checkInServiceAccounts rotates the passwords of the given accounts of a set and
+// makes them available again. Unless forced or disabled on the set, only the
+// entity that checked out an account may check it in.
+func (b *jenkinsBackend) checkInServiceAccounts(ctx context.Context, req *logical.Request, d *framework.FieldData, force bool) (*logical.Response, error) {
+	b.checkOutLock.Lock()
+	defer b.checkOutLock.Unlock()
+
+	set, err := getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return logical.ErrorResponse("unknown library set"), nil
+	}
+
+	enforce := !force && !set.DisableCheckInEnforcement
+
+	usernames := d.Get("service_account_names").([]string)
+	if len(usernames) == 0 {
+		for _, username := range set.ServiceAccountNames {
+			checkOut, err := getCheckOut(ctx, req.Storage, username)
+			if err != nil {
+				return nil, err
+			}
+			if checkOut != nil && checkOut.BorrowerEntityID == req.EntityID {
+				usernames = append(usernames, username)
+			}
+		}
+	}
+
+	checkIns := []string{}
+	for _, username := range usernames {
+		if !set.hasServiceAccount(username) {
+			return logical.ErrorResponse("service account %q does not belong to set %q", username, set.Name), nil
+		}
+
+		checkOut, err := getCheckOut(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if checkOut == nil {
+			return logical.ErrorResponse("%s: %s", errNotCheckedOut.Error(), username), nil
+		}
+
+		if enforce && checkOut.BorrowerEntityID != req.EntityID {
+			return logical.ErrorResponse("service account %q can only be checked in by the entity that checked it out", username), nil
+		}
+
+		if err := b.checkIn(ctx, req.Storage, username); err != nil {
+			return nil, err
+		}
+
+		checkIns = append(checkIns, username)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"check_ins": checkIns,
+		},
+	}, nil
+}
+
+// pathLibraryStatus returns which accounts of the set are available and who holds the others
+func (b *jenkinsBackend) pathLibraryStatus(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	set, err := getLibrarySet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if set == nil {
+		return nil, nil
+	}
+
+	respData := map[string]interface{}{}
+	for _, username := range set.ServiceAccountNames {
+		checkOut, err := getCheckOut(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
+
+		status := map[string]interface{}{
+			"available": checkOut == nil,
+		}
+		if checkOut != nil {
+			status["borrower_entity_id"] = checkOut.BorrowerEntityID
+			status["check_out_time"] = checkOut.CheckOutTime
+		}
+		respData[username] = status
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+// containsString returns whether the slice contains the value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	pathLibraryHelpSyn = `
+Manage a set of existing Jenkins users that can be checked out.
+`
+
+	pathLibraryHelpDesc = `
+This path configures a library set of existing Jenkins users.
+Each user can be checked out by one entity at a time and gets
+a new password on every check-out and check-in.
+`
+
+	pathLibraryListHelpSyn = `
+List library sets.
+`
+
+	pathLibraryListHelpDesc = `
+List all library sets created under /library mount.
+`
+
+	pathLibraryCheckOutHelpSyn = `
+Check out an available account of a library set.
+`
+
+	pathLibraryCheckOutHelpDesc = `
+This path checks out the first available account of the set,
+rotates its password and returns it with a lease. The account
+is checked in when the lease is revoked.
+`
+
+	pathLibraryCheckInHelpSyn = `
+Check in accounts of a library set.
+`
+
+	pathLibraryCheckInHelpDesc = `
+This path rotates the password of checked out accounts and makes
+them available again. Unless check-in enforcement is disabled on
+the set, only the entity that checked out an account can check it in.
+`
+
+	pathLibraryManageCheckInHelpSyn = `
+Check in accounts of a library set held by any entity.
+`
+
+	pathLibraryManageCheckInHelpDesc = `
+This path checks in accounts regardless of the entity holding them
+and is meant for operators.
+`
+
+	pathLibraryStatusHelpSyn = `
+Show which accounts of a library set are checked out.
+`
+
+	pathLibraryStatusHelpDesc = `
+This path lists every account of the set, whether it is available
+and which entity is holding it.
+`
+)
@@ -0,0 +1,103 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLibrarySetName  = "test-library-set"
+	testLibraryUsername = "testLibraryUsername"
+	testEntityID        = "test-entity-id"
+)
+
+// TestLibrary tests checking out and checking in an account of a library set
+func TestLibrary(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	userPath := fmt.Sprintf("%s/%s", usersPrefix, testLibraryUsername)
+	err := testUserCreate(t, b, s, userPath, map[string]interface{}{
+		"password": testUserPassword,
+		"fullname": testUserFullname,
+		"email":    testUserEmail,
+	})
+	require.NoError(t, err)
+
+	setPath := fmt.Sprintf("%s/%s", libraryPrefix, testLibrarySetName)
+
+	t.Run("Check out and check in", func(t *testing.T) {
+		resp, err := testLibraryRequest(b, s, logical.CreateOperation, setPath, map[string]interface{}{
+			"service_account_names": testLibraryUsername,
+			"ttl":                   "1h",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testLibraryRequest(b, s, logical.UpdateOperation, setPath+"/check-out", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.NotNil(t, resp.Secret)
+		require.Equal(t, testLibraryUsername, resp.Data["username"])
+
+		// Only one account in the set
+		resp, err = testLibraryRequest(b, s, logical.UpdateOperation, setPath+"/check-out", nil)
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = testLibraryRequest(b, s, logical.ReadOperation, setPath+"/status", nil)
+		require.NoError(t, err)
+		require.Equal(t, false, resp.Data[testLibraryUsername].(map[string]interface{})["available"])
+
+		resp, err = testLibraryRequest(b, s, logical.UpdateOperation, setPath+"/check-in", nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{testLibraryUsername}, resp.Data["check_ins"])
+
+		resp, err = testLibraryRequest(b, s, logical.ReadOperation, setPath+"/status", nil)
+		require.NoError(t, err)
+		require.Equal(t, true, resp.Data[testLibraryUsername].(map[string]interface{})["available"])
+	})
+
+	t.Run("Check in is enforced for other entities", func(t *testing.T) {
+		resp, err := testLibraryRequest(b, s, logical.UpdateOperation, setPath+"/check-out", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      setPath + "/check-in",
+			Data:      map[string]interface{}{"service_account_names": testLibraryUsername},
+			Storage:   s,
+			EntityID:  testEntityID,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		resp, err = testLibraryRequest(b, s, logical.UpdateOperation, fmt.Sprintf("%s/manage/%s/check-in", libraryPrefix, testLibrarySetName), map[string]interface{}{
+			"service_account_names": testLibraryUsername,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{testLibraryUsername}, resp.Data["check_ins"])
+
+		resp, err = testLibraryRequest(b, s, logical.DeleteOperation, setPath, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+
+	err = testUserDelete(t, b, s, userPath)
+	require.NoError(t, err)
+}
+
+// Utility function to send a request for library paths
+func testLibraryRequest(b logical.Backend, s logical.Storage, op logical.Operation, path string, d map[string]interface{}) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Data:      d,
+		Storage:   s,
+	})
+}
@@ -0,0 +1,97 @@
+package jenkinssecretsengine
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const managePrefix = "manage"
+
+// pathManageUsers extends the Vault API with a `/manage/users`
+// endpoint to administer users owned by any entity.
+func pathManageUsers(b *jenkinsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s/%s", managePrefix, usersPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the Jenkins user",
+					Required:    true,
+				},
+				"password": {
+					Type:        framework.TypeString,
+					Description: "Password for the Jenkins user",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"fullname": {
+					Type:        framework.TypeString,
+					Description: "Fullname for the Jenkins user",
+					Required:    false,
+				},
+				"email": {
+					Type:        framework.TypeString,
+					Description: "Email for the Jenkins user",
+					Required:    false,
+				},
+				"ssh_public_keys": {
+					Type:        framework.TypeStringSlice,
+					Description: sshPublicKeysFieldDescription,
+					Required:    false,
+				},
+				"force": {
+					Type:        framework.TypeBool,
+					Description: "Delete a Jenkins user not created by Vault",
+					Required:    false,
+				},
+				"check_jenkins": {
+					Type:        framework.TypeBool,
+					Description: "Check on read whether the user still exists in Jenkins and matches the inventory",
+					Required:    false,
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathUsersRead,
+				logical.UpdateOperation: b.pathUsersUpdate,
+				logical.DeleteOperation: b.pathUsersDelete,
+			},
+			HelpSynopsis:    pathManageUsersHelpSyn,
+			HelpDescription: pathManageUsersHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/%s/rotate$", managePrefix, usersPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the Jenkins user",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathUsersRotate,
+				},
+			},
+			HelpSynopsis:    pathUsersRotateHelpSyn,
+			HelpDescription: pathUsersRotateHelpDesc,
+		},
+	}
+}
+
+const (
+	pathManageUsersHelpSyn = `
+Administer Jenkins users owned by any entity.
+`
+
+	pathManageUsersHelpDesc = `
+This path reads, updates and deletes users of the /users inventory
+regardless of the entity that created them. When owner_only is set on
+the config, requests under /users from other entities are refused, so
+access to this path should be limited to administrators.
+`
+)
@@ -0,0 +1,347 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rolesPrefix = "roles"
+	credsPrefix = "creds"
+)
+
+// pathRoles extends the Vault API with `/roles` and `/creds` endpoints
+// to issue API tokens for existing Jenkins users other than the configured one.
+func pathRoles(b *jenkinsBackend) []*framework.Path {
+	roleFields := map[string]*framework.FieldSchema{
+		"name": {
+			Type:        framework.TypeLowerCaseString,
+			Description: "Name of the role",
+			Required:    true,
+		},
+		"allowed_usernames": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Existing Jenkins users API tokens can be issued for. Supports globs such as svc-*.",
+			Required:    true,
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Default lease for tokens issued by the role. If not set or set to 0, will use system default.",
+		},
+		"max_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Maximum time for tokens issued by the role. If not set or set to 0, will use system default.",
+		},
+	}
+
+	// The user policy applies to users created through the role
+	for name, field := range userPolicyFields() {
+		roleFields[name] = field
+	}
+
+	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  roleFields,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathRolesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathRolesWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRolesWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathRolesDelete,
+				},
+			},
+			ExistenceCheck:  b.pathRolesExistenceCheck,
+			HelpSynopsis:    pathRolesHelpSyn,
+			HelpDescription: pathRolesHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/?$", rolesPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathRolesList,
+				},
+			},
+			HelpSynopsis:    pathRolesListHelpSyn,
+			HelpDescription: pathRolesListHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", credsPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the role",
+					Required:    true,
+				},
+				"username": {
+					Type:        framework.TypeString,
+					Description: "Existing Jenkins user to issue the API token for",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathCredsRead,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathCredsRead,
+				},
+			},
+			HelpSynopsis:    pathCredsHelpSyn,
+			HelpDescription: pathCredsHelpDesc,
+		},
+	}
+}
+
+// pathRolesExistenceCheck verifies if a role exists.
+func (b *jenkinsBackend) pathRolesExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, req.Path)
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %w", err)
+	}
+
+	return out != nil, nil
+}
+
+// pathRolesList makes a request to Vault storage to retrieve a list of roles for the backend
+func (b *jenkinsBackend) pathRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, fmt.Sprintf("%s/", rolesPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathRolesRead returns a role
+func (b *jenkinsBackend) pathRolesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	role, err := getRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: role.toResponseData(),
+	}, nil
+}
+
+// pathRolesWrite creates or updates a role
+func (b *jenkinsBackend) pathRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	createOperation := (req.Operation == logical.CreateOperation)
+
+	if role == nil {
+		if !createOperation {
+			return nil, errors.New("role not found during update operation")
+		}
+		role = &jenkinsRole{
+			Name: name,
+		}
+	}
+
+	if allowedUsernames, ok := d.GetOk("allowed_usernames"); ok {
+		role.AllowedUsernames = allowedUsernames.([]string)
+	}
+
+	if len(role.AllowedUsernames) == 0 {
+		return logical.ErrorResponse("missing allowed_usernames"), nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Globs are checked when tokens are issued
+	for _, username := range role.AllowedUsernames {
+		if config != nil && !strings.Contains(username, "*") && config.isProtectedUser(username) {
+			return logical.ErrorResponse("user %q is protected and can not be allowed by a role", username), nil
+		}
+	}
+
+	if ttl, ok := d.GetOk("ttl"); ok {
+		role.TTL = time.Duration(ttl.(int)) * time.Second
+	}
+
+	if maxTTL, ok := d.GetOk("max_ttl"); ok {
+		role.MaxTTL = time.Duration(maxTTL.(int)) * time.Second
+	}
+
+	if role.MaxTTL > 0 && role.TTL > role.MaxTTL {
+		return logical.ErrorResponse("ttl can not be greater than max_ttl"), nil
+	}
+
+	if err := role.UserPolicy.update(d); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return nil, putRole(ctx, req.Storage, role)
+}
+
+// pathRolesDelete removes a role. Tokens it issued stay valid until their lease ends.
+func (b *jenkinsBackend) pathRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	err := req.Storage.Delete(ctx, getRolePath(d.Get("name").(string)))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting role: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathCredsRead issues an API token for an existing Jenkins user allowed by a role
+func (b *jenkinsBackend) pathCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	role, err := getRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return logical.ErrorResponse("unknown role"), nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	if !config.AllowOnBehalfTokens {
+		return logical.ErrorResponse("issuing tokens for other users is disabled, set allow_on_behalf_tokens on /%s", configPrefix), nil
+	}
+
+	username := d.Get("username").(string)
+	if username == "" {
+		return logical.ErrorResponse("missing username"), nil
+	}
+
+	if !role.allowsUsername(username) {
+		return logical.ErrorResponse("username %q is not allowed by role %q", username, role.Name), nil
+	}
+
+	// Globs such as * would otherwise reach the configured and protected users
+	if config.isProtectedUser(username) {
+		return logical.ErrorResponse("user %q is protected and can not be issued tokens", username), nil
+	}
+
+	return b.createOnBehalfToken(ctx, req, role, username)
+}
+
+// createOnBehalfToken creates a new Jenkins token for another user to store into the Vault
+// backend, generates a response with the secrets information, and sets the TTL of the role.
+func (b *jenkinsBackend) createOnBehalfToken(ctx context.Context, req *logical.Request, role *jenkinsRole, username string) (*logical.Response, error) {
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	walID, jenkinsName, err := putTokenWAL(ctx, req.Storage, username, role.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := createOnBehalfToken(ctx, client, username, jenkinsName)
+	if err != nil {
+		return nil, err
+	}
+	token.Name = role.Name
+
+	// Need to store the owner and token ID to revoke later, ttl to renew later
+	internalData := newLeaseInternalData(role.TTL, role.MaxTTL)
+	internalData.Username = username
+	internalData.TokenID = token.TokenID
+	internalData.TokenName = role.Name
+
+	respData := token.toResponseData()
+	respData["username"] = username
+
+	resp := b.Secret(jenkinsTokenType).Response(respData, internalData.toMap())
+
+	tokenSalt, err := b.getSalt(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to storage to view token inventory, without the token value
+	err = putTokenEntry(ctx, req.Storage, &jenkinsTokenEntry{
+		CreationTime: time.Now(),
+		TokenID:      token.TokenID,
+		Name:         role.Name,
+		EntityID:     req.EntityID,
+		TokenHash:    tokenSalt.GetHMAC(token.Token),
+		Username:     username,
+		RoleName:     role.Name,
+		TTL:          role.TTL,
+		MaxTTL:       role.MaxTTL,
+	})
+	if err != nil {
+		return logical.ErrorResponse("error writing token to internal storage"), err
+	}
+
+	b.deleteWAL(ctx, req.Storage, walID)
+
+	if role.TTL > 0 {
+		resp.Secret.TTL = role.TTL
+	}
+	if role.MaxTTL > 0 {
+		resp.Secret.MaxTTL = role.MaxTTL
+	}
+
+	return resp, nil
+}
+
+const (
+	pathRolesHelpSyn = `
+Manage roles issuing API tokens for existing Jenkins users.
+`
+
+	pathRolesHelpDesc = `
+This path configures a role allowing API tokens to be issued under /creds
+for the existing Jenkins users listed in allowed_usernames. Tokens are
+created and revoked through the Jenkins script console, which requires
+allow_on_behalf_tokens to be set on the /config mount. Users created under
+/users with role set must also be allowed by the role, and follow its
+allowed_email_domains, username_regex, reserved_usernames and
+username_prefix over those of /config.
+`
+
+	pathRolesListHelpSyn = `
+List roles.
+`
+
+	pathRolesListHelpDesc = `
+List all roles created under /roles mount.
+`
+
+	pathCredsHelpSyn = `
+Generate a Jenkins API token for an existing user allowed by a role.
+`
+
+	pathCredsHelpDesc = `
+This path generates a Jenkins API token for the given username as long as
+the role allows it. The token is revoked when its lease ends.
+`
+)
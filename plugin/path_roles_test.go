@@ -0,0 +1,141 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRoleName     = "test-role"
+	testRoleUsername = "testRoleUsername"
+)
+
+// TestRole tests issuing and revoking API tokens for another Jenkins user through a role
+func TestRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	err := testUserCreate(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, testRoleUsername), map[string]interface{}{
+		"password": testUserPassword,
+		"fullname": testUserFullname,
+		"email":    testUserEmail,
+	})
+	require.NoError(t, err)
+
+	rolePath := fmt.Sprintf("%s/%s", rolesPrefix, testRoleName)
+	credsPath := fmt.Sprintf("%s/%s", credsPrefix, testRoleName)
+
+	resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, rolePath, map[string]interface{}{
+		"allowed_usernames": "testRole*",
+		"ttl":               "1h",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	t.Run("Tokens for other users are disabled by default", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.UpdateOperation, credsPath, map[string]interface{}{
+			"username": testRoleUsername,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	_, err = testStaticRoleRequest(b, s, logical.UpdateOperation, configPrefix, map[string]interface{}{
+		"allow_on_behalf_tokens": true,
+		"validate":               false,
+	})
+	require.NoError(t, err)
+
+	t.Run("Username not allowed by role is rejected", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.UpdateOperation, credsPath, map[string]interface{}{
+			"username": testUsername,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Issue and revoke token for another user", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.UpdateOperation, credsPath, map[string]interface{}{
+			"username": testRoleUsername,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.Equal(t, testRoleUsername, resp.Data["username"])
+		tokenID := resp.Data["token_id"].(string)
+
+		// The token authenticates as the other user
+		verifyResp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("%s/verify", tokensPrefix),
+			Data:      map[string]interface{}{"token": resp.Data["token"], "username": testRoleUsername},
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, true, verifyResp.Data["valid"])
+		require.Equal(t, testRoleUsername, verifyResp.Data["username"])
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.NoError(t, err)
+
+		client, err := b.getClient(context.Background(), s)
+		require.NoError(t, err)
+		exists, err := client.hasAPIToken(context.Background(), testRoleUsername, tokenID)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	err = testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, testRoleUsername))
+	require.NoError(t, err)
+}
+
+// TestRoleAllowsUsername tests matching usernames against the allowed usernames of a role
+func TestRoleAllowsUsername(t *testing.T) {
+	role := &jenkinsRole{AllowedUsernames: []string{"svc-*", "alice"}}
+	require.True(t, role.allowsUsername("svc-build"))
+	require.True(t, role.allowsUsername("alice"))
+	require.False(t, role.allowsUsername("bob"))
+}
+
+// TestRoleProtectedUser ensures roles can't issue tokens for the configured or protected users
+func TestRoleProtectedUser(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username":               testUsername,
+		"password":               testPassword,
+		"url":                    testURL,
+		"protected_users":        "jenkins-ops",
+		"allow_on_behalf_tokens": true,
+		"validate":               false,
+	})
+	require.NoError(t, err)
+
+	resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, fmt.Sprintf("%s/%s", rolesPrefix, "ops"), map[string]interface{}{
+		"allowed_usernames": "svc-*,jenkins-ops",
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	rolePath := fmt.Sprintf("%s/%s", rolesPrefix, testRoleName)
+	resp, err = testStaticRoleRequest(b, s, logical.CreateOperation, rolePath, map[string]interface{}{
+		"allowed_usernames": "*",
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	for _, username := range []string{testUsername, "Jenkins-Ops"} {
+		resp, err := testStaticRoleRequest(b, s, logical.UpdateOperation, fmt.Sprintf("%s/%s", credsPrefix, testRoleName), map[string]interface{}{
+			"username": username,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), username)
+	}
+}
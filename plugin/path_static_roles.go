@@ -0,0 +1,344 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	staticRolesPrefix = "static-roles"
+	staticCredsPrefix = "static-creds"
+	rotateRolePrefix  = "rotate-role"
+
+	defaultRotationPeriod = 24 * time.Hour
+)
+
+// pathStaticRoles extends the Vault API with `/static-roles`, `/static-creds`
+// and `/rotate-role` endpoints for existing Jenkins users managed by Vault.
+func pathStaticRoles(b *jenkinsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s", staticRolesPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+				"username": {
+					Type:        framework.TypeString,
+					Description: "Existing Jenkins user whose credential is managed by the role. Can not be changed once set.",
+					Required:    true,
+				},
+				"credential_type": {
+					Type:          framework.TypeString,
+					Description:   "Credential managed by the role, either password or api_token. The password of the user is left unchanged for api_token roles. Can not be changed once set.",
+					Default:       credentialTypePassword,
+					AllowedValues: []interface{}{credentialTypePassword, credentialTypeAPIToken},
+				},
+				"rotation_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often the credential of the user is rotated. Defaults to 24h.",
+					Default:     int(defaultRotationPeriod.Seconds()),
+				},
+				"rotation_grace_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the previous API token stays valid after a rotation. Only used for api_token roles.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesRead,
+				},
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesWrite,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesWrite,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesDelete,
+				},
+			},
+			ExistenceCheck:  b.pathStaticRolesExistenceCheck,
+			HelpSynopsis:    pathStaticRolesHelpSyn,
+			HelpDescription: pathStaticRolesHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/?$", staticRolesPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathStaticRolesList,
+				},
+			},
+			HelpSynopsis:    pathStaticRolesListHelpSyn,
+			HelpDescription: pathStaticRolesListHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", staticCredsPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathStaticCredsRead,
+				},
+			},
+			HelpSynopsis:    pathStaticCredsHelpSyn,
+			HelpDescription: pathStaticCredsHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s", rotateRolePrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Name of the static role",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRotateRoleWrite,
+				},
+			},
+			HelpSynopsis:    pathRotateRoleHelpSyn,
+			HelpDescription: pathRotateRoleHelpDesc,
+		},
+	}
+}
+
+// pathStaticRolesExistenceCheck verifies if a static role exists.
+func (b *jenkinsBackend) pathStaticRolesExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, req.Path)
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %w", err)
+	}
+
+	return out != nil, nil
+}
+
+// pathStaticRolesList makes a request to Vault storage to retrieve a list of static roles for the backend
+func (b *jenkinsBackend) pathStaticRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, fmt.Sprintf("%s/", staticRolesPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// pathStaticRolesRead returns a static role without its password
+func (b *jenkinsBackend) pathStaticRolesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	role, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: role.toResponseData(),
+	}, nil
+}
+
+// pathStaticRolesWrite creates or updates a static role. The credential of the user
+// is rotated when the role is created so that Vault knows the current one.
+func (b *jenkinsBackend) pathStaticRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	name := d.Get("name").(string)
+	role, err := getStaticRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	createOperation := (req.Operation == logical.CreateOperation)
+
+	if role == nil {
+		if !createOperation {
+			return nil, errors.New("static role not found during update operation")
+		}
+		role = &jenkinsStaticRole{
+			Name: name,
+		}
+	}
+
+	if username, ok := d.GetOk("username"); ok {
+		if !createOperation && username.(string) != role.Username {
+			return logical.ErrorResponse("username of a static role can not be changed"), nil
+		}
+		role.Username = username.(string)
+	} else if createOperation {
+		return logical.ErrorResponse("missing username"), nil
+	}
+
+	if credentialType, ok := d.GetOk("credential_type"); ok {
+		if !createOperation && credentialType.(string) != role.credentialType() {
+			return logical.ErrorResponse("credential_type of a static role can not be changed"), nil
+		}
+		role.CredentialType = credentialType.(string)
+	} else if createOperation {
+		role.CredentialType = d.Get("credential_type").(string)
+	}
+
+	if role.CredentialType != credentialTypePassword && role.CredentialType != credentialTypeAPIToken {
+		return logical.ErrorResponse("credential_type must be %s or %s", credentialTypePassword, credentialTypeAPIToken), nil
+	}
+
+	if rotationPeriod, ok := d.GetOk("rotation_period"); ok {
+		role.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
+	} else if createOperation {
+		role.RotationPeriod = time.Duration(d.Get("rotation_period").(int)) * time.Second
+	}
+
+	if gracePeriod, ok := d.GetOk("rotation_grace_period"); ok {
+		role.GracePeriod = time.Duration(gracePeriod.(int)) * time.Second
+	}
+
+	if role.RotationPeriod < time.Minute {
+		return logical.ErrorResponse("rotation_period must be at least 1m"), nil
+	}
+
+	if role.GracePeriod < 0 || role.GracePeriod >= role.RotationPeriod {
+		return logical.ErrorResponse("rotation_grace_period must be shorter than rotation_period"), nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rotating the configured user would lock the plugin out of Jenkins
+	if config != nil && config.isProtectedUser(role.Username) {
+		return logical.ErrorResponse("user %q is protected and can not be managed by a static role", role.Username), nil
+	}
+
+	if !createOperation {
+		return nil, putStaticRole(ctx, req.Storage, role)
+	}
+
+	if err := b.rotateStaticRole(ctx, req.Storage, role); err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	return nil, nil
+}
+
+// pathStaticRolesDelete removes a static role and revokes the API tokens it issued.
+// The Jenkins user keeps its last password.
+func (b *jenkinsBackend) pathStaticRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return nil, nil
+	}
+
+	if err := b.revokeStaticTokens(ctx, req.Storage, role); err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	err = req.Storage.Delete(ctx, getStaticRolePath(role.Name))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting static role: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathStaticCredsRead returns the current password of a static role
+func (b *jenkinsBackend) pathStaticCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	role, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return logical.ErrorResponse("unknown static role"), nil
+	}
+
+	return &logical.Response{
+		Data: role.toCredsResponseData(),
+	}, nil
+}
+
+// pathRotateRoleWrite rotates the credential of a static role immediately
+func (b *jenkinsBackend) pathRotateRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := getStaticRole(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return logical.ErrorResponse("unknown static role"), nil
+	}
+
+	if err := b.rotateStaticRole(ctx, req.Storage, role); err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	return nil, nil
+}
+
+const (
+	pathStaticRolesHelpSyn = `
+Manage an existing Jenkins user with a password or API token rotated by Vault.
+`
+
+	pathStaticRolesHelpDesc = `
+This path configures a static role for an existing Jenkins user.
+The credential of the user is rotated when the role is created and
+every rotation_period after that. For api_token roles the previous
+token stays valid for rotation_grace_period after each rotation.
+
+Tokens of api_token roles are issued and revoked through the Jenkins
+script console, so the password of the user is never changed and
+other systems logging in as the user keep working.
+`
+
+	pathStaticRolesListHelpSyn = `
+List static roles.
+`
+
+	pathStaticRolesListHelpDesc = `
+List all static roles created under /static-roles mount.
+`
+
+	pathStaticCredsHelpSyn = `
+Read the current credentials of a static role.
+`
+
+	pathStaticCredsHelpDesc = `
+This path returns the current password or API token of the Jenkins
+user managed by a static role along with when it was last rotated by Vault.
+`
+
+	pathRotateRoleHelpSyn = `
+Rotate the credential of a static role.
+`
+
+	pathRotateRoleHelpDesc = `
+This path rotates the password or API token of the Jenkins user
+managed by a static role immediately.
+`
+)
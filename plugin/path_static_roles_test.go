@@ -0,0 +1,110 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testStaticRoleName     = "test-static-role"
+	testStaticRoleUsername = "testStaticUsername"
+)
+
+// TestStaticRole tests the creation, rotation and read of a static role
+// for a Jenkins user created by the plugin
+func TestStaticRole(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	err := testUserCreate(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, testStaticRoleUsername), map[string]interface{}{
+		"password": testUserPassword,
+		"fullname": testUserFullname,
+		"email":    testUserEmail,
+	})
+	require.NoError(t, err)
+
+	rolePath := fmt.Sprintf("%s/%s", staticRolesPrefix, testStaticRoleName)
+	credsPath := fmt.Sprintf("%s/%s", staticCredsPrefix, testStaticRoleName)
+
+	t.Run("Static role for configured user is rejected", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, rolePath, map[string]interface{}{
+			"username": testUsername,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("Create, rotate and read static role", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, rolePath, map[string]interface{}{
+			"username":        testStaticRoleUsername,
+			"rotation_period": "1h",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testStaticRoleRequest(b, s, logical.ReadOperation, credsPath, nil)
+		require.NoError(t, err)
+		require.Equal(t, testStaticRoleUsername, resp.Data["username"])
+		password := resp.Data["password"]
+		require.NotEqual(t, testUserPassword, password)
+
+		resp, err = testStaticRoleRequest(b, s, logical.UpdateOperation, fmt.Sprintf("%s/%s", rotateRolePrefix, testStaticRoleName), nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testStaticRoleRequest(b, s, logical.ReadOperation, credsPath, nil)
+		require.NoError(t, err)
+		require.NotEqual(t, password, resp.Data["password"])
+
+		resp, err = testStaticRoleRequest(b, s, logical.DeleteOperation, rolePath, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+
+	t.Run("Create and rotate static token role with grace period", func(t *testing.T) {
+		resp, err := testStaticRoleRequest(b, s, logical.CreateOperation, rolePath, map[string]interface{}{
+			"username":              testStaticRoleUsername,
+			"credential_type":       credentialTypeAPIToken,
+			"rotation_period":       "1h",
+			"rotation_grace_period": "5m",
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testStaticRoleRequest(b, s, logical.ReadOperation, credsPath, nil)
+		require.NoError(t, err)
+		require.NotContains(t, resp.Data, "password")
+		tokenID := resp.Data["token_id"]
+		require.NotEmpty(t, tokenID)
+
+		resp, err = testStaticRoleRequest(b, s, logical.UpdateOperation, fmt.Sprintf("%s/%s", rotateRolePrefix, testStaticRoleName), nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+
+		resp, err = testStaticRoleRequest(b, s, logical.ReadOperation, credsPath, nil)
+		require.NoError(t, err)
+		require.NotEqual(t, tokenID, resp.Data["token_id"])
+		require.Equal(t, tokenID, resp.Data["previous_token_id"])
+
+		resp, err = testStaticRoleRequest(b, s, logical.DeleteOperation, rolePath, nil)
+		require.NoError(t, err)
+		require.Nil(t, resp)
+	})
+
+	err = testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, testStaticRoleUsername))
+	require.NoError(t, err)
+}
+
+// Utility function to send a request for static role paths
+func testStaticRoleRequest(b logical.Backend, s logical.Storage, op logical.Operation, path string, d map[string]interface{}) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Data:      d,
+		Storage:   s,
+	})
+}
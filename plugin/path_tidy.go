@@ -0,0 +1,407 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tidyPrefix = "tidy"
+	// tidyOrphansPath stores when tidy first saw each orphaned user
+	tidyOrphansPath = "tidy/orphaned-users"
+
+	tidyStateInactive = "Inactive"
+	tidyStateRunning  = "Running"
+	tidyStateFinished = "Finished"
+	tidyStateError    = "Error"
+)
+
+// errTidyRunning is returned when a tidy operation is already in progress
+var errTidyRunning = errors.New("tidy operation already in progress")
+
+// tidyStatus reports the progress of the last tidy operation
+type tidyStatus struct {
+	TimeStarted    time.Time
+	TimeFinished   time.Time
+	State          string
+	Error          string
+	TokensRevoked  int
+	UsersDeleted   int
+	OrphansPending int
+}
+
+// toResponseData returns response data for a tidy status
+func (status *tidyStatus) toResponseData() map[string]interface{} {
+	respData := map[string]interface{}{
+		"state":           status.State,
+		"error":           status.Error,
+		"time_started":    status.TimeStarted,
+		"time_finished":   status.TimeFinished,
+		"tokens_revoked":  status.TokensRevoked,
+		"users_deleted":   status.UsersDeleted,
+		"orphans_pending": status.OrphansPending,
+	}
+	return respData
+}
+
+// pathTidy extends the Vault API with `/tidy` endpoints to remove
+// Jenkins tokens and users created by the plugin that Vault lost track of.
+func pathTidy(b *jenkinsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s$", tidyPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTidyWrite,
+				},
+			},
+			HelpSynopsis:    pathTidyHelpSyn,
+			HelpDescription: pathTidyHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/status$", tidyPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTidyStatusRead,
+				},
+			},
+			HelpSynopsis:    pathTidyStatusHelpSyn,
+			HelpDescription: pathTidyStatusHelpDesc,
+		},
+	}
+}
+
+// pathTidyWrite runs a tidy operation and returns its result
+func (b *jenkinsBackend) pathTidyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	status, err := b.runTidy(ctx, req.Storage)
+	if errors.Is(err, errTidyRunning) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	return &logical.Response{
+		Data: status.toResponseData(),
+	}, nil
+}
+
+// pathTidyStatusRead returns the status of the last tidy operation
+func (b *jenkinsBackend) pathTidyStatusRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.tidyStatusLock.RLock()
+	defer b.tidyStatusLock.RUnlock()
+
+	status := b.tidyStatus
+	if status == nil {
+		status = &tidyStatus{State: tidyStateInactive}
+	}
+
+	return &logical.Response{
+		Data: status.toResponseData(),
+	}, nil
+}
+
+// autoTidy runs a tidy operation when the configured tidy interval has elapsed
+func (b *jenkinsBackend) autoTidy(ctx context.Context, s logical.Storage) error {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config == nil || config.TidyInterval <= 0 || time.Since(b.lastAutoTidy) < config.TidyInterval {
+		return nil
+	}
+
+	b.lastAutoTidy = time.Now()
+
+	_, err = b.runTidy(ctx, s)
+	if errors.Is(err, errTidyRunning) {
+		return nil
+	}
+
+	return err
+}
+
+// runTidy runs a tidy operation unless one is already in progress and records its status
+func (b *jenkinsBackend) runTidy(ctx context.Context, s logical.Storage) (*tidyStatus, error) {
+	if !atomic.CompareAndSwapUint32(&b.tidyRunning, 0, 1) {
+		return nil, errTidyRunning
+	}
+	defer atomic.StoreUint32(&b.tidyRunning, 0)
+
+	status := &tidyStatus{
+		TimeStarted: time.Now(),
+		State:       tidyStateRunning,
+	}
+	b.setTidyStatus(status)
+
+	err := b.tidy(ctx, s, status)
+
+	status.TimeFinished = time.Now()
+	status.State = tidyStateFinished
+	if err != nil {
+		status.State = tidyStateError
+		status.Error = err.Error()
+	}
+	b.setTidyStatus(status)
+
+	return status, err
+}
+
+// setTidyStatus stores a copy of the status for /tidy/status
+func (b *jenkinsBackend) setTidyStatus(status *tidyStatus) {
+	b.tidyStatusLock.Lock()
+	defer b.tidyStatusLock.Unlock()
+
+	current := *status
+	b.tidyStatus = &current
+}
+
+// tidy revokes Jenkins tokens and deletes Jenkins users that carry the plugin's marker
+// but have no inventory entry once they are older than the safety buffer.
+func (b *jenkinsBackend) tidy(ctx context.Context, s logical.Storage, status *tidyStatus) error {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		return fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	owners, err := tokenOwners(ctx, s, config)
+	if err != nil {
+		return err
+	}
+
+	_, staticTokenIDs, err := staticRoleTokens(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for _, owner := range owners {
+		if err := b.tidyTokens(ctx, s, client, config, owner, staticTokenIDs, status); err != nil {
+			return err
+		}
+	}
+
+	users, err := client.listUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Jenkins doesn't expose when a user was created, so the safety
+	// buffer counts from when tidy first saw the user orphaned
+	firstSeen, err := getTidyOrphans(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	orphans := map[string]time.Time{}
+	for _, user := range users {
+		if user.Description != jenkinsUserDescription {
+			continue
+		}
+
+		entry, err := b.getUserFromStorage(ctx, s, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if entry != nil {
+			continue
+		}
+
+		seen, ok := firstSeen[user.ID]
+		if !ok {
+			seen = time.Now()
+		}
+
+		if time.Since(seen) < config.TidySafetyBuffer {
+			orphans[user.ID] = seen
+			status.OrphansPending++
+			continue
+		}
+
+		if err := deleteUser(ctx, client, user.ID); err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("error deleting orphaned user %q: %w", user.ID, err)
+		}
+
+		b.Logger().Info("deleted orphaned user", "username", user.ID)
+		status.UsersDeleted++
+	}
+
+	return putTidyOrphans(ctx, s, orphans)
+}
+
+// tidyTokens revokes the tokens of a Jenkins user that carry the plugin's prefix but have
+// no inventory entry nor static role once they are older than the safety buffer
+func (b *jenkinsBackend) tidyTokens(ctx context.Context, s logical.Storage, client *jenkinsClient, config *jenkinsConfig, owner string, staticTokenIDs map[string]bool, status *tidyStatus) error {
+	tokens, err := client.listAPITokens(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	// Tokens of the configured user are revoked through the API, others through the script console
+	username := owner
+	if sameUsername(owner, config.Username) {
+		username = ""
+	}
+
+	for _, token := range tokens {
+		if !strings.HasPrefix(token.Name, jenkinsTokenNamePrefix) {
+			continue
+		}
+
+		entry, err := getTokenFromStorage(ctx, s, token.UUID)
+		if err != nil {
+			return err
+		}
+
+		if entry != nil || staticTokenIDs[token.UUID] {
+			continue
+		}
+
+		// The inventory entry may not have been written yet
+		if time.Since(token.creationTime()) < config.TidySafetyBuffer {
+			status.OrphansPending++
+			continue
+		}
+
+		if err := deleteTokenOf(ctx, client, username, token.UUID); err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("error revoking orphaned token %q: %w", token.UUID, err)
+		}
+
+		b.Logger().Info("revoked orphaned token", "token_id", token.UUID, "token_name", token.Name, "username", owner)
+		status.TokensRevoked++
+	}
+
+	return nil
+}
+
+// tokenOwners returns the Jenkins users the plugin may have issued tokens for: the
+// configured user, the owners of tokens in the inventory, the users roles name
+// without globs and the users of API token static roles
+func tokenOwners(ctx context.Context, s logical.Storage, config *jenkinsConfig) ([]string, error) {
+	owners := []string{config.Username}
+	seen := map[string]bool{foldUsername(config.Username): true}
+	add := func(owner string) {
+		if owner != "" && !seen[foldUsername(owner)] {
+			seen[foldUsername(owner)] = true
+			owners = append(owners, owner)
+		}
+	}
+
+	tokenIDs, err := s.List(ctx, fmt.Sprintf("%s/", tokensPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tokenID := range tokenIDs {
+		entry, err := getTokenFromStorage(ctx, s, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != nil {
+			add(entry.Username)
+		}
+	}
+
+	roleNames, err := s.List(ctx, fmt.Sprintf("%s/", rolesPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range roleNames {
+		role, err := getRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil {
+			continue
+		}
+
+		for _, username := range role.AllowedUsernames {
+			if !strings.Contains(username, "*") {
+				add(username)
+			}
+		}
+	}
+
+	staticUsernames, _, err := staticRoleTokens(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, username := range staticUsernames {
+		add(username)
+	}
+
+	return owners, nil
+}
+
+// getTidyOrphans returns when tidy first saw each orphaned user
+func getTidyOrphans(ctx context.Context, s logical.Storage) (map[string]time.Time, error) {
+	orphans := map[string]time.Time{}
+
+	entry, err := s.Get(ctx, tidyOrphansPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return orphans, nil
+	}
+
+	if err := entry.DecodeJSON(&orphans); err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// putTidyOrphans stores when tidy first saw each orphaned user
+func putTidyOrphans(ctx context.Context, s logical.Storage, orphans map[string]time.Time) error {
+	entry, err := logical.StorageEntryJSON(tidyOrphansPath, orphans)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+const (
+	pathTidyHelpSyn = `
+Remove Jenkins tokens and users the plugin lost track of.
+`
+
+	pathTidyHelpDesc = `
+This path revokes Jenkins API tokens of the configured user and deletes
+Jenkins users that were created by the plugin but are no longer in its
+inventory, for example because a lease was lost or a revocation failed.
+Objects are only removed once they have been orphaned for longer than
+tidy_safety_buffer. Tidy also runs every tidy_interval when configured.
+`
+
+	pathTidyStatusHelpSyn = `
+Show the result of the last tidy operation.
+`
+
+	pathTidyStatusHelpDesc = `
+This path returns the state of the last tidy operation and how many
+tokens and users it removed.
+`
+)
@@ -0,0 +1,50 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTidy tests that tokens created by the plugin without an inventory entry are revoked
+func TestTidy(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	// The API refuses a buffer shorter than the WAL rollback, so store it directly to
+	// tidy the token created below right away
+	config, err := getConfig(context.Background(), s)
+	require.NoError(t, err)
+	config.TidySafetyBuffer = 0
+	entry, err := logical.StorageEntryJSON(configPrefix, config)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(context.Background(), entry))
+
+	t.Run("Tidy orphaned token", func(t *testing.T) {
+		client, err := b.getClient(context.Background(), s)
+		require.NoError(t, err)
+
+		// Simulate a token whose lease was lost
+		_, err = createToken(context.Background(), client, jenkinsTokenNamePrefix+testTokenName)
+		require.NoError(t, err)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      tidyPrefix,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, tidyStateFinished, resp.Data["state"])
+		require.GreaterOrEqual(t, resp.Data["tokens_revoked"], 1)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      tidyPrefix + "/status",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.Equal(t, tidyStateFinished, resp.Data["state"])
+	})
+}
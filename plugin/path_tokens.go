@@ -17,6 +17,77 @@ const tokensPrefix = "tokens"
 // endpoint for an api token.
 func pathTokens(b *jenkinsBackend) []*framework.Path {
 	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/verify$", tokensPrefix),
+			Fields: map[string]*framework.FieldSchema{
+				"token": {
+					Type:        framework.TypeString,
+					Description: "Jenkins API token to verify.",
+					Required:    true,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"username": {
+					Type:        framework.TypeString,
+					Description: "Jenkins user the token belongs to. Defaults to the user the token was issued for.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTokensVerify,
+				},
+			},
+			HelpSynopsis:    pathTokensVerifyHelpSyn,
+			HelpDescription: pathTokensVerifyHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/batch$", tokensPrefix),
+			Fields: map[string]*framework.FieldSchema{
+				"count": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Number of tokens to generate, at most %d.", maxTokenBatchCount),
+					Required:    true,
+				},
+				"name_template": {
+					Type:        framework.TypeString,
+					Description: fmt.Sprintf("Name of the tokens, %s is replaced by the index of each token starting at 1.", tokenBatchIndexPlaceholder),
+					Default:     defaultTokenBatchNameTemplate,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Default lease for the generated tokens. If not set or set to 0, will use system default.",
+				},
+				"max_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Maximum time for the tokens. If not set or set to 0, will use system default.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathTokensBatchWrite,
+				},
+			},
+			HelpSynopsis:    pathTokensBatchHelpSyn,
+			HelpDescription: pathTokensBatchHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/info/%s", tokensPrefix, framework.GenericNameRegex("token_id")),
+			Fields: map[string]*framework.FieldSchema{
+				"token_id": {
+					Type:        framework.TypeString,
+					Description: "ID of the Jenkins API token.",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTokensInfoRead,
+				},
+			},
+			HelpSynopsis:    pathTokensInfoHelpSyn,
+			HelpDescription: pathTokensInfoHelpDesc,
+		},
 		{
 			Pattern: fmt.Sprintf("%s/%s", tokensPrefix, framework.GenericNameRegex("name")),
 			Fields: map[string]*framework.FieldSchema{
@@ -30,6 +101,11 @@ func pathTokens(b *jenkinsBackend) []*framework.Path {
 					Description: "Maximum time for token. If not set or set to 0, will use system default.",
 					Required:    false,
 				},
+				"format": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: formatFieldDescription,
+					Required:    false,
+				},
 			},
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.ReadOperation:   b.pathTokensRead,
@@ -38,7 +114,263 @@ func pathTokens(b *jenkinsBackend) []*framework.Path {
 			HelpSynopsis:    pathTokensHelpSyn,
 			HelpDescription: pathTokensHelpDesc,
 		},
+		{
+			Pattern: fmt.Sprintf("%s/?$", tokensPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathTokensList,
+				},
+			},
+			HelpSynopsis:    pathTokensListHelpSyn,
+			HelpDescription: pathTokensListHelpDesc,
+		},
+	}
+}
+
+// pathTokensList lists the IDs of outstanding tokens along with their inventory data
+func (b *jenkinsBackend) pathTokensList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	tokenIDs, err := req.Storage.List(ctx, fmt.Sprintf("%s/", tokensPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := map[string]interface{}{}
+	for _, tokenID := range tokenIDs {
+		entry, err := getTokenFromStorage(ctx, req.Storage, tokenID)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry == nil {
+			continue
+		}
+
+		keyInfo[tokenID] = entry.toKeyInfo()
+	}
+
+	return logical.ListResponseWithInfo(tokenIDs, keyInfo), nil
+}
+
+// pathTokensVerify authenticates to Jenkins with a token and reports who it resolves to,
+// their main permissions and whether the token belongs to a live lease of this mount
+func (b *jenkinsBackend) pathTokensVerify(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	token := d.Get("token").(string)
+	if token == "" {
+		return logical.ErrorResponse("missing token"), nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	invalid := &logical.Response{
+		Data: map[string]interface{}{
+			"valid":      false,
+			"live_lease": false,
+		},
+	}
+
+	// Only tokens issued by this mount are sent to Jenkins, anything else
+	// would let callers guess the passwords of any Jenkins user
+	tokenSalt, err := b.getSalt(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := findTokenByHash(ctx, req.Storage, tokenSalt.GetHMAC(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return invalid, nil
+	}
+
+	username := entry.owner(config)
+	if usernameRaw, ok := d.GetOk("username"); ok && !sameUsername(usernameRaw.(string), username) {
+		return invalid, nil
+	}
+
+	tokenClient, err := newClient(&jenkinsConfig{
+		URL:      config.URL,
+		Username: username,
+		Password: token,
+	})
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	identity, err := tokenClient.whoAmI(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token was revoked in Jenkins out-of-band
+	if identity == nil || !identity.Authenticated || identity.Anonymous {
+		return invalid, nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := client.getUserPermissions(ctx, identity.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"valid":       true,
+			"username":    identity.Name,
+			"authorities": identity.Authorities,
+			"permissions": permissions,
+			"live_lease":  true,
+			"token_id":    entry.TokenID,
+			"token_name":  entry.Name,
+		},
+	}, nil
+}
+
+// pathTokensInfoRead returns what Jenkins knows about a token merged with its inventory entry
+func (b *jenkinsBackend) pathTokensInfoRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	tokenID := d.Get("token_id").(string)
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := getTokenFromStorage(ctx, req.Storage, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens issued for other users are looked up in the account of their owner
+	username := config.Username
+	if entry != nil {
+		username = entry.owner(config)
+	}
+
+	token, err := client.getAPIToken(ctx, username, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == nil && entry == nil {
+		return nil, nil
+	}
+
+	respData := map[string]interface{}{
+		"token_id":       tokenID,
+		"jenkins_exists": token != nil,
+		"in_inventory":   entry != nil,
 	}
+
+	if token != nil {
+		for key, value := range token.toResponseData() {
+			respData[key] = value
+		}
+	}
+
+	if entry != nil {
+		for key, value := range entry.toKeyInfo() {
+			respData[key] = value
+		}
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+// pathTokensBatchWrite creates several Jenkins tokens returned under a single token lease
+func (b *jenkinsBackend) pathTokensBatchWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	count := d.Get("count").(int)
+	if count < 1 || count > maxTokenBatchCount {
+		return logical.ErrorResponse("count must be between 1 and %d", maxTokenBatchCount), nil
+	}
+
+	names, err := tokenBatchNames(d.Get("name_template").(string), count)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+	maxTtl := time.Duration(d.Get("max_ttl").(int)) * time.Second
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	tokenSalt, err := b.getSalt(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, walIDs, err := b.createTokenBatch(ctx, req.Storage, config.Username, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every token keeps its own inventory entry, a response only carries one lease
+	internalData := newLeaseInternalData(ttl, maxTtl)
+	respTokens := make([]map[string]interface{}, 0, len(tokens))
+	for _, token := range tokens {
+		err := putTokenEntry(ctx, req.Storage, &jenkinsTokenEntry{
+			CreationTime: time.Now(),
+			TokenID:      token.TokenID,
+			Name:         token.Name,
+			EntityID:     req.EntityID,
+			TokenHash:    tokenSalt.GetHMAC(token.Token),
+			TTL:          ttl,
+			MaxTTL:       maxTtl,
+		})
+		if err != nil {
+			b.rollbackTokenBatch(ctx, req.Storage, tokens, walIDs)
+			return logical.ErrorResponse("error writing token to internal storage"), err
+		}
+
+		internalData.TokenIDs = append(internalData.TokenIDs, token.TokenID)
+		respTokens = append(respTokens, token.toResponseData())
+	}
+
+	for _, walID := range walIDs {
+		b.deleteWAL(ctx, req.Storage, walID)
+	}
+
+	resp := b.Secret(jenkinsTokenType).Response(map[string]interface{}{
+		"tokens": respTokens,
+	}, internalData.toMap())
+
+	if ttl > 0 {
+		resp.Secret.TTL = ttl
+	}
+	if maxTtl > 0 {
+		resp.Secret.MaxTTL = maxTtl
+	}
+
+	return resp, nil
 }
 
 // pathTokensRead creates a new Jenkins token each time it is called if a user exists.
@@ -50,14 +382,50 @@ func (b *jenkinsBackend) pathTokensRead(ctx context.Context, req *logical.Reques
 		MaxTTL: maxTtl,
 	}
 
-	return b.createUserToken(ctx, req, *jenkinsTokenConfig)
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	formats := d.Get("format").([]string)
+	if err := validateCredentialFormats(formats, config.URL); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp, err := b.createUserToken(ctx, req, *jenkinsTokenConfig)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+
+	addCredentialFormats(resp, config, formats, config.Username, resp.Data["token"].(string))
+
+	return resp, nil
 }
 
 // createUserToken creates a new Jenkins token to store into the Vault backend, generates
 // a response with the secrets information, and checks the TTL and MaxTTL attributes.
 func (b *jenkinsBackend) createUserToken(ctx context.Context, req *logical.Request, jenkinsToken jenkinsToken) (*logical.Response, error) {
 	tokenName := strings.TrimPrefix(req.Path, fmt.Sprintf("%s/", tokensPrefix))
-	token, err := b.createToken(ctx, req.Storage, tokenName)
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	walID, jenkinsName, err := putTokenWAL(ctx, req.Storage, config.Username, tokenName)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := b.createToken(ctx, req.Storage, jenkinsName)
 	if err != nil {
 		return nil, err
 	}
@@ -66,16 +434,34 @@ func (b *jenkinsBackend) createUserToken(ctx context.Context, req *logical.Reque
 	// It's only available in the initial read response
 	token.Name = tokenName
 
-	// Need to store token ID to revoke later
-	internalData := map[string]interface{}{
-		"token_id":   token.TokenID,
-		"token_name": tokenName,
-		"ttl":        token.TTL,
-		"max_ttl":    token.MaxTTL,
-	}
+	// Need to store token ID to revoke later, ttl to renew later
+	internalData := newLeaseInternalData(jenkinsToken.TTL, jenkinsToken.MaxTTL)
+	internalData.TokenID = token.TokenID
+	internalData.TokenName = tokenName
 
 	// Create secret with lease
-	resp := b.Secret(jenkinsTokenType).Response(token.toResponseData(), internalData)
+	resp := b.Secret(jenkinsTokenType).Response(token.toResponseData(), internalData.toMap())
+
+	tokenSalt, err := b.getSalt(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to storage to view token inventory, without the token value
+	err = putTokenEntry(ctx, req.Storage, &jenkinsTokenEntry{
+		CreationTime: time.Now(),
+		TokenID:      token.TokenID,
+		Name:         tokenName,
+		EntityID:     req.EntityID,
+		TokenHash:    tokenSalt.GetHMAC(token.Token),
+		TTL:          jenkinsToken.TTL,
+		MaxTTL:       jenkinsToken.MaxTTL,
+	})
+	if err != nil {
+		return logical.ErrorResponse("error writing token to internal storage"), err
+	}
+
+	b.deleteWAL(ctx, req.Storage, walID)
 
 	if jenkinsToken.TTL > 0 {
 		resp.Secret.TTL = jenkinsToken.TTL
@@ -87,8 +473,8 @@ func (b *jenkinsBackend) createUserToken(ctx context.Context, req *logical.Reque
 	return resp, nil
 }
 
-// createToken uses the Jenkins client create a new token
-func (b *jenkinsBackend) createToken(ctx context.Context, s logical.Storage, tokenName string) (*jenkinsToken, error) {
+// createToken uses the Jenkins client create a new token under its Jenkins name
+func (b *jenkinsBackend) createToken(ctx context.Context, s logical.Storage, jenkinsName string) (*jenkinsToken, error) {
 	client, err := b.getClient(ctx, s)
 	if err != nil {
 		return nil, err
@@ -96,7 +482,7 @@ func (b *jenkinsBackend) createToken(ctx context.Context, s logical.Storage, tok
 
 	var token *jenkinsToken
 
-	token, err = createToken(ctx, client, tokenName)
+	token, err = createToken(ctx, client, jenkinsName)
 	if err != nil {
 		return nil, fmt.Errorf("error creating Jenkins token: %w", err)
 	}
@@ -116,5 +502,53 @@ Generate a Jenkins API token for the configured user.
 	pathTokensHelpDesc = `
 This path generates a Jenkins API tokens
 for the user configured under the /config mount.
+`
+
+	pathTokensListHelpSyn = `
+List Jenkins API tokens.
+`
+
+	pathTokensListHelpDesc = `
+List the IDs of all Jenkins API tokens issued under /tokens mount
+that have not been revoked yet, along with who requested them.
+`
+
+	pathTokensVerifyHelpSyn = `
+Verify a Jenkins API token.
+`
+
+	pathTokensVerifyHelpDesc = `
+This path authenticates to Jenkins with the given token and returns the
+user it resolves to, the user's authorities and main permissions, and
+whether the token belongs to a live lease issued under the /tokens mount.
+Tokens not issued by this mount are reported invalid without contacting
+Jenkins.
+`
+
+	pathTokensBatchHelpSyn = `
+Generate several Jenkins API tokens for the configured user.
+`
+
+	pathTokensBatchHelpDesc = `
+This path generates count Jenkins API tokens for the user configured
+under the /config mount, named after name_template. The tokens are
+created in parallel. If any token can't be created, the tokens already
+created are revoked and the request fails.
+
+A Vault response carries a single lease, so the tokens are returned
+under one token lease that revokes and renews each of them like the
+lease of a token under /tokens/<name>. Renewing fails if any token
+no longer exists in Jenkins.
+`
+
+	pathTokensInfoHelpSyn = `
+Show what Jenkins knows about an API token.
+`
+
+	pathTokensInfoHelpDesc = `
+This path looks up an API token of the user configured under the /config
+mount by ID and returns its Jenkins name, creation date, use counter,
+last use date and legacy flag, along with its inventory entry when the
+token was issued under the /tokens mount.
 `
 )
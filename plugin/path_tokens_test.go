@@ -3,7 +3,9 @@ package jenkinssecretsengine
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/require"
@@ -22,6 +24,268 @@ func TestToken(t *testing.T) {
 		require.NotNil(t, resp)
 		require.Equal(t, resp.Data["token_name"], testTokenName)
 	})
+
+	t.Run("List and revoke Token", func(t *testing.T) {
+		resp, err := testTokenRead(t, b, s)
+		require.Nil(t, err)
+		tokenID := resp.Data["token_id"].(string)
+
+		listResp, err := testTokenList(t, b, s)
+		require.Nil(t, err)
+		require.Contains(t, listResp.Data["keys"], tokenID)
+		keyInfo := listResp.Data["key_info"].(map[string]interface{})[tokenID].(map[string]interface{})
+		require.Equal(t, testTokenName, keyInfo["token_name"])
+		require.NotContains(t, keyInfo, "token")
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.Nil(t, err)
+
+		listResp, err = testTokenList(t, b, s)
+		require.Nil(t, err)
+		require.NotContains(t, listResp.Data["keys"], tokenID)
+	})
+
+	t.Run("Create Token with formats", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("%s/%s", tokensPrefix, testTokenName),
+			Data:      map[string]interface{}{"format": "basic_auth_header,env_export"},
+			Storage:   s,
+		})
+		require.Nil(t, err)
+		require.Contains(t, resp.Data[formatBasicAuthHeader], "Authorization: Basic ")
+		require.Contains(t, resp.Data[formatEnvExport], resp.Data["token"])
+		require.NotContains(t, resp.Data, formatNetrc)
+	})
+
+	t.Run("Create and revoke Token batch", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("%s/batch", tokensPrefix),
+			Data:      map[string]interface{}{"count": 3, "name_template": "runner-{{index}}"},
+			Storage:   s,
+		})
+		require.Nil(t, err)
+		require.Nil(t, resp.Error())
+
+		tokens := resp.Data["tokens"].([]map[string]interface{})
+		require.Len(t, tokens, 3)
+		require.Equal(t, "runner-1", tokens[0]["token_name"])
+
+		listResp, err := testTokenList(t, b, s)
+		require.Nil(t, err)
+		for _, token := range tokens {
+			require.Contains(t, listResp.Data["keys"], token["token_id"])
+		}
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.NoError(t, err)
+
+		// A token revoked out-of-band fails the renewal of the lease
+		client, err := b.getClient(context.Background(), s)
+		require.NoError(t, err)
+		require.NoError(t, deleteToken(context.Background(), client, tokens[0]["token_id"].(string)))
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.Error(t, err)
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.Nil(t, err)
+
+		listResp, err = testTokenList(t, b, s)
+		require.Nil(t, err)
+		for _, token := range tokens {
+			require.NotContains(t, listResp.Data["keys"], token["token_id"])
+		}
+	})
+
+	t.Run("Verify Token", func(t *testing.T) {
+		resp, err := testTokenRead(t, b, s)
+		require.Nil(t, err)
+
+		verifyResp, err := testTokenVerify(t, b, s, resp.Data["token"].(string))
+		require.Nil(t, err)
+		require.Equal(t, true, verifyResp.Data["valid"])
+		require.Equal(t, true, verifyResp.Data["live_lease"])
+		require.Equal(t, resp.Data["token_id"], verifyResp.Data["token_id"])
+		require.Contains(t, verifyResp.Data["permissions"], "Overall/Read")
+
+		verifyResp, err = testTokenVerify(t, b, s, "invalid")
+		require.Nil(t, err)
+		require.Equal(t, false, verifyResp.Data["valid"])
+	})
+
+	t.Run("Token info", func(t *testing.T) {
+		resp, err := testTokenRead(t, b, s)
+		require.Nil(t, err)
+		tokenID := resp.Data["token_id"].(string)
+
+		infoResp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      fmt.Sprintf("%s/info/%s", tokensPrefix, tokenID),
+			Storage:   s,
+		})
+		require.Nil(t, err)
+		require.Equal(t, true, infoResp.Data["jenkins_exists"])
+		require.Equal(t, true, infoResp.Data["in_inventory"])
+		require.True(t, strings.HasPrefix(infoResp.Data["jenkins_name"].(string), jenkinsTokenNamePrefix+testTokenName+"-"))
+		require.Equal(t, testTokenName, infoResp.Data["token_name"])
+		require.Equal(t, 0, infoResp.Data["use_counter"])
+		require.Nil(t, infoResp.Data["last_use_date"])
+
+		infoResp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      fmt.Sprintf("%s/info/unknown", tokensPrefix),
+			Storage:   s,
+		})
+		require.Nil(t, err)
+		require.Nil(t, infoResp)
+	})
+
+	t.Run("Renew Token", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("%s/%s", tokensPrefix, testTokenName),
+			Data:      map[string]interface{}{"ttl": "1h"},
+			Storage:   s,
+		})
+		require.Nil(t, err)
+		require.Equal(t, time.Hour, resp.Secret.TTL)
+
+		renewResp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.Nil(t, err)
+		require.Equal(t, time.Hour, renewResp.Secret.TTL)
+	})
+
+	t.Run("Renew Token revoked in Jenkins", func(t *testing.T) {
+		resp, err := testTokenRead(t, b, s)
+		require.Nil(t, err)
+
+		client, err := b.getClient(context.Background(), s)
+		require.Nil(t, err)
+		require.Nil(t, deleteToken(context.Background(), client, resp.Data["token_id"].(string)))
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no longer exists")
+	})
+
+	t.Run("Revoke Token already revoked in Jenkins", func(t *testing.T) {
+		resp, err := testTokenRead(t, b, s)
+		require.Nil(t, err)
+		tokenID := resp.Data["token_id"].(string)
+
+		client, err := b.getClient(context.Background(), s)
+		require.Nil(t, err)
+		require.Nil(t, deleteToken(context.Background(), client, tokenID))
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		require.Nil(t, err)
+
+		listResp, err := testTokenList(t, b, s)
+		require.Nil(t, err)
+		require.NotContains(t, listResp.Data["keys"], tokenID)
+	})
+}
+
+// TestIdleToken tests that tokens unused for longer than the idle timeout are revoked
+func TestIdleToken(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPrefix,
+		Data:      map[string]interface{}{"idle_timeout": "1s", "validate": false},
+		Storage:   s,
+	})
+	require.Nil(t, err)
+
+	resp, err := testTokenRead(t, b, s)
+	require.Nil(t, err)
+	tokenID := resp.Data["token_id"].(string)
+
+	time.Sleep(2 * time.Second)
+	require.Nil(t, b.revokeIdleTokens(context.Background(), s))
+
+	client, err := b.getClient(context.Background(), s)
+	require.Nil(t, err)
+	exists, err := client.hasAPIToken(context.Background(), testUsername, tokenID)
+	require.Nil(t, err)
+	require.False(t, exists)
+
+	// The entry is kept and marked until the lease is revoked, renewals report the revocation
+	listResp, err := testTokenList(t, b, s)
+	require.Nil(t, err)
+	require.Contains(t, listResp.Data["keys"], tokenID)
+	require.Contains(t, listResp.Data["key_info"].(map[string]interface{})[tokenID], "idle_revoked_at")
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	require.Error(t, err)
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	require.Nil(t, err)
+
+	listResp, err = testTokenList(t, b, s)
+	require.Nil(t, err)
+	require.NotContains(t, listResp.Data["keys"], tokenID)
+}
+
+// Utility function to verify a token
+func testTokenVerify(t *testing.T, b *jenkinsBackend, s logical.Storage, token string) (*logical.Response, error) {
+	t.Helper()
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("%s/verify", tokensPrefix),
+		Data:      map[string]interface{}{"token": token},
+		Storage:   s,
+	})
+}
+
+// Utility function to list the token inventory
+func testTokenList(t *testing.T, b *jenkinsBackend, s logical.Storage) (*logical.Response, error) {
+	t.Helper()
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      fmt.Sprintf("%s/", tokensPrefix),
+		Storage:   s,
+	})
 }
 
 // Utility function to create a token by reading and return any errors
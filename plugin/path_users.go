@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -17,6 +20,90 @@ const usersPrefix = "users"
 // endpoint for a user.
 func pathUsers(b *jenkinsBackend) []*framework.Path {
 	return []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/import$", usersPrefix),
+			Fields: map[string]*framework.FieldSchema{
+				"usernames": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Existing Jenkins users to import",
+					Required:    false,
+				},
+				"filter": {
+					Type:        framework.TypeString,
+					Description: "Regular expression matching the names of the existing Jenkins users to import",
+					Required:    false,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Time after which imported users are revoked. If not set or set to 0, they never expire.",
+					Required:    false,
+				},
+				"delete_on_revoke": {
+					Type:        framework.TypeBool,
+					Description: "Revoke the imported users with the revoke_action of /config, which deletes them from Jenkins by default. If not set, revoking them only restores their original description and removes them from the inventory.",
+					Required:    false,
+				},
+				"entity_id": {
+					Type:        framework.TypeString,
+					Description: "Entity owning the imported users. Defaults to the entity of the request.",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathUsersImport,
+				},
+			},
+			HelpSynopsis:    pathUsersImportHelpSyn,
+			HelpDescription: pathUsersImportHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/bulk$", usersPrefix),
+			Fields: map[string]*framework.FieldSchema{
+				"users": {
+					Type:        framework.TypeSlice,
+					Description: "Users to create, as objects with username, and optionally password, fullname and email",
+					Required:    false,
+				},
+				"csv": {
+					Type:        framework.TypeString,
+					Description: "Users to create as CSV text, with a header line naming the username, password, fullname and email columns",
+					Required:    false,
+				},
+				"all_or_nothing": {
+					Type:        framework.TypeBool,
+					Description: "Delete every user created by the request if any of them can't be created",
+					Required:    false,
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Default lease shared by the users. If not set or set to 0, will use system default.",
+					Required:    false,
+				},
+				"max_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Maximum time for the users. If not set or set to 0, will use system default.",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathUsersBulkWrite,
+				},
+			},
+			HelpSynopsis:    pathUsersBulkHelpSyn,
+			HelpDescription: pathUsersBulkHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/drift$", usersPrefix),
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathUsersDrift,
+				},
+			},
+			HelpSynopsis:    pathUsersDriftHelpSyn,
+			HelpDescription: pathUsersDriftHelpDesc,
+		},
 		{
 			Pattern: fmt.Sprintf("%s/%s", usersPrefix, framework.GenericNameRegex("name")),
 			Fields: map[string]*framework.FieldSchema{
@@ -48,6 +135,31 @@ func pathUsers(b *jenkinsBackend) []*framework.Path {
 					Description: "Maximum time for a user. If not set or set to 0, will use system default.",
 					Required:    false,
 				},
+				"format": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: formatFieldDescription,
+					Required:    false,
+				},
+				"force": {
+					Type:        framework.TypeBool,
+					Description: "Delete a Jenkins user not created by Vault, or one kept in Jenkins by the lock or disable revoke_action",
+					Required:    false,
+				},
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Role under /roles the user is created through. The role must allow the username, and its TTLs are used unless ttl or max_ttl are set.",
+					Required:    false,
+				},
+				"ssh_public_keys": {
+					Type:        framework.TypeStringSlice,
+					Description: sshPublicKeysFieldDescription,
+					Required:    false,
+				},
+				"check_jenkins": {
+					Type:        framework.TypeBool,
+					Description: "Check on read whether the user still exists in Jenkins and matches the inventory",
+					Required:    false,
+				},
 			},
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.ReadOperation:   b.pathUsersRead,
@@ -59,8 +171,52 @@ func pathUsers(b *jenkinsBackend) []*framework.Path {
 			HelpSynopsis:    pathUsersHelpSyn,
 			HelpDescription: pathUsersHelpDesc,
 		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/rotate$", usersPrefix, framework.GenericNameRegex("name")),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the Jenkins user",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathUsersRotate,
+				},
+			},
+			HelpSynopsis:    pathUsersRotateHelpSyn,
+			HelpDescription: pathUsersRotateHelpDesc,
+		},
 		{
 			Pattern: fmt.Sprintf("%s/?$", usersPrefix),
+			Fields: map[string]*framework.FieldSchema{
+				"email_domain": {
+					Type:        framework.TypeString,
+					Description: "Only list users with an email in this domain",
+					Required:    false,
+				},
+				"role": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "Only list users created through this role",
+					Required:    false,
+				},
+				"created_before": {
+					Type:        framework.TypeTime,
+					Description: "Only list users added to the inventory before this RFC 3339 time",
+					Required:    false,
+				},
+				"after": {
+					Type:        framework.TypeString,
+					Description: "Only list users whose name sorts after this one, to fetch the next page",
+					Required:    false,
+				},
+				"limit": {
+					Type:        framework.TypeInt,
+					Description: "Maximum number of users to list. If not set or set to 0, all users are listed.",
+					Required:    false,
+				},
+			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ListOperation: &framework.PathOperation{
 					Callback: b.pathUsersList,
@@ -72,6 +228,71 @@ func pathUsers(b *jenkinsBackend) []*framework.Path {
 	}
 }
 
+// validateUserEmail returns an error response if a new email of a user breaks the
+// policy of the role it was created through, or of the config
+func (b *jenkinsBackend) validateUserEmail(ctx context.Context, s logical.Storage, user *jenkinsUser, email string) (*logical.Response, error) {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	var role *jenkinsRole
+	if user.RoleName != "" {
+		if role, err = getRole(ctx, s, user.RoleName); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := resolveUserPolicy(config, role).validateEmail(email); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return nil, nil
+}
+
+// checkUserOwner refuses requests on a user from entities other than the one that created
+// it when owner_only is set on the config. Requests under /manage/users bypass the check.
+func (b *jenkinsBackend) checkUserOwner(ctx context.Context, req *logical.Request, user *jenkinsUser) (*logical.Response, error) {
+	if strings.HasPrefix(req.Path, fmt.Sprintf("%s/", managePrefix)) {
+		return nil, nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil || !config.OwnerOnly || user.EntityID == req.EntityID {
+		return nil, nil
+	}
+
+	return logical.ErrorResponse("user %q is owned by another entity, use /%s/%s/%s to manage it", user.Username, managePrefix, usersPrefix, user.Username), logical.ErrPermissionDenied
+}
+
+// revokedUserResponse returns the error response for changes to a locked or disabled user
+func revokedUserResponse(user *jenkinsUser) *logical.Response {
+	return logical.ErrorResponse("user %q was revoked with action %s, delete it with force to remove it from Jenkins before creating it again", user.Username, user.RevokeAction)
+}
+
+// checkProtectedUser returns an error response if a user is protected in the
+// config and therefore can't be created, deleted or rotated.
+func (b *jenkinsBackend) checkProtectedUser(ctx context.Context, s logical.Storage, username, action string) (*logical.Response, error) {
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil && config.isProtectedUser(username) {
+		return logical.ErrorResponse("user %q is protected and can not be %s", username, action), nil
+	}
+
+	return nil, nil
+}
+
 // pathUsersExistenceCheck verifies if a user exists.
 func (b *jenkinsBackend) pathUsersExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 	out, err := req.Storage.Get(ctx, req.Path)
@@ -84,12 +305,62 @@ func (b *jenkinsBackend) pathUsersExistenceCheck(ctx context.Context, req *logic
 
 // pathUserList makes a request to Vault storage to retrieve a list of roles for the backend
 func (b *jenkinsBackend) pathUsersList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	entries, err := req.Storage.List(ctx, fmt.Sprintf("%s/", usersPrefix))
+	limit := d.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("limit can not be negative"), nil
+	}
+
+	after := d.Get("after").(string)
+	emailDomain := strings.ToLower(strings.TrimPrefix(d.Get("email_domain").(string), "@"))
+	role := d.Get("role").(string)
+	createdBefore := d.Get("created_before").(time.Time)
+
+	// The storage API has no paged listing, so pages are cut from the sorted keys
+	usernames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", usersPrefix))
 	if err != nil {
 		return nil, err
 	}
+	sort.Strings(usernames)
+
+	// Users up to after belong to previous pages and are never read
+	if after != "" {
+		usernames = usernames[sort.Search(len(usernames), func(i int) bool { return usernames[i] > after }):]
+	}
+
+	keys := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, username := range usernames {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+
+		user, err := b.getUserFromStorage(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			continue
+		}
+
+		if emailDomain != "" && !strings.HasSuffix(strings.ToLower(user.Email), "@"+emailDomain) {
+			continue
+		}
 
-	return logical.ListResponse(entries), nil
+		if role != "" && user.RoleName != role {
+			continue
+		}
+
+		// Users added before creation times were recorded are never excluded
+		if !createdBefore.IsZero() && !user.CreationTime.IsZero() && !user.CreationTime.Before(createdBefore) {
+			continue
+		}
+
+		keys = append(keys, username)
+		keyInfo[username] = user.toKeyInfo()
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
 }
 
 // pathUsersRead returns a Jenkins user object in storage
@@ -104,8 +375,98 @@ func (b *jenkinsBackend) pathUsersRead(ctx context.Context, req *logical.Request
 		return nil, nil
 	}
 
-	return &logical.Response{
+	if resp, err := b.checkUserOwner(ctx, req, entry); resp != nil || err != nil {
+		return resp, err
+	}
+
+	resp := &logical.Response{
 		Data: entry.toResponseData(),
+	}
+
+	if !d.Get("check_jenkins").(bool) {
+		return resp, nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.getUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Data["jenkins_exists"] = info != nil
+	if info != nil {
+		resp.Data["drift"] = entry.drift(info)
+	}
+
+	return resp, nil
+}
+
+// pathUsersDrift compares the users/ inventory with the users of Jenkins
+func (b *jenkinsBackend) pathUsersDrift(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	usernames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", usersPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := client.listUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jenkinsUsers := map[string]*jenkinsUserInfo{}
+	for i := range users {
+		jenkinsUsers[foldUsername(users[i].ID)] = &users[i]
+	}
+
+	missing := []string{}
+	mismatched := map[string]interface{}{}
+	inventory := map[string]bool{}
+	for _, username := range usernames {
+		inventory[foldUsername(username)] = true
+
+		user, err := b.getUserFromStorage(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			continue
+		}
+
+		info, ok := jenkinsUsers[foldUsername(username)]
+		if !ok {
+			missing = append(missing, username)
+			continue
+		}
+
+		if drift := user.drift(info); len(drift) > 0 {
+			mismatched[username] = drift
+		}
+	}
+
+	// Marked users missing from the inventory are orphans tidy would delete
+	unmanaged := []string{}
+	for _, user := range users {
+		if user.Description == jenkinsUserDescription && !inventory[foldUsername(user.ID)] {
+			unmanaged = append(unmanaged, user.ID)
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"missing_in_jenkins":      missing,
+			"mismatched":              mismatched,
+			"marked_not_in_inventory": unmanaged,
+		},
 	}, nil
 }
 
@@ -113,13 +474,46 @@ func (b *jenkinsBackend) pathUsersRead(ctx context.Context, req *logical.Request
 func (b *jenkinsBackend) pathUsersDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	username := b.parseUsernameFromPath(req.Path)
 
+	if resp, err := b.checkProtectedUser(ctx, req.Storage, username, "deleted"); resp != nil || err != nil {
+		return resp, err
+	}
+
+	user, err := b.getUserFromStorage(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only users managed by Vault are deleted unless forced
+	if user == nil && !d.Get("force").(bool) {
+		return logical.ErrorResponse("user %q is not managed by Vault, set force to delete it anyway", username), nil
+	}
+
+	if user != nil {
+		if resp, err := b.checkUserOwner(ctx, req, user); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
 
+	// Managed users are revoked with the configured action, only force deletes them from Jenkins
+	if user != nil && !d.Get("force").(bool) {
+		if !user.RevokedAt.IsZero() {
+			return revokedUserResponse(user), nil
+		}
+
+		if err := b.revokeUser(ctx, req.Storage, client, username); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
 	err = deleteUser(ctx, client, username)
-	if err != nil {
+	if err != nil && !errors.Is(err, errNotFound) {
 		return logical.ErrorResponse(err.Error()), err
 	}
 
@@ -138,11 +532,16 @@ func (b *jenkinsBackend) pathUsersWrite(ctx context.Context, req *logical.Reques
 		return logical.ErrorResponse(err.Error()), err
 	}
 
+	username := b.parseUsernameFromPath(req.Path)
+
+	if resp, err := b.checkProtectedUser(ctx, req.Storage, username, "managed"); resp != nil || err != nil {
+		return resp, err
+	}
+
 	if exists {
-		return logical.ErrorResponse("user already exists"), nil
+		return b.pathUsersUpdate(ctx, req, d)
 	}
 
-	username := b.parseUsernameFromPath(req.Path)
 	password := d.Get("password").(string)
 	fullname := d.Get("fullname").(string)
 	email := d.Get("email").(string)
@@ -157,39 +556,479 @@ func (b *jenkinsBackend) pathUsersWrite(ctx context.Context, req *logical.Reques
 		MaxTTL:   maxTtl,
 	}
 
-	return b.createJenkinsUser(ctx, req, *jenkinsUserConfig)
+	sshPublicKeys, err := parseSSHPublicKeys(d.Get("ssh_public_keys").([]string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	jenkinsUserConfig.SSHPublicKeys = sshPublicKeys
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	var role *jenkinsRole
+	if roleName := d.Get("role").(string); roleName != "" {
+		role, err = getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil {
+			return logical.ErrorResponse("unknown role"), nil
+		}
+
+		if !role.allowsUsername(username) {
+			return logical.ErrorResponse("username %q is not allowed by role %q", username, role.Name), nil
+		}
+
+		jenkinsUserConfig.RoleName = role.Name
+		if _, ok := d.GetOk("ttl"); !ok {
+			jenkinsUserConfig.TTL = role.TTL
+		}
+		if _, ok := d.GetOk("max_ttl"); !ok {
+			jenkinsUserConfig.MaxTTL = role.MaxTTL
+		}
+	}
+
+	formats := d.Get("format").([]string)
+	if err := validateCredentialFormats(formats, config.URL); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp, err := b.createJenkinsUser(ctx, req, *jenkinsUserConfig, resolveUserPolicy(config, role))
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+
+	addCredentialFormats(resp, config, formats, username, password)
+
+	return resp, nil
+}
+
+// pathUsersUpdate changes the password, full name or email of an existing
+// user in Jenkins and refreshes its inventory entry
+func (b *jenkinsBackend) pathUsersUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// The lease and the credential renderings are only set when a user is created
+	for _, field := range []string{"ttl", "max_ttl", "format"} {
+		if _, ok := d.GetOk(field); ok {
+			return logical.ErrorResponse("%s can only be set when creating a user", field), nil
+		}
+	}
+
+	username := b.parseUsernameFromPath(req.Path)
+	user, err := b.getUserFromStorage(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return logical.ErrorResponse("unknown user"), nil
+	}
+
+	if resp, err := b.checkUserOwner(ctx, req, user); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if !user.RevokedAt.IsZero() {
+		return revokedUserResponse(user), nil
+	}
+
+	// Every field is validated before Jenkins is changed so a rejected update changes nothing
+	fullname, fullnameOk := d.GetOk("fullname")
+	email, emailOk := d.GetOk("email")
+	if emailOk {
+		if resp, err := b.validateUserEmail(ctx, req.Storage, user, email.(string)); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	// An empty list removes the keys of the user
+	rawSSHPublicKeys, sshPublicKeysOk := d.GetOk("ssh_public_keys")
+	var sshPublicKeys []string
+	if sshPublicKeysOk {
+		if sshPublicKeys, err = parseSSHPublicKeys(rawSSHPublicKeys.([]string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if password, ok := d.GetOk("password"); ok {
+		if err := client.setUserPassword(ctx, username, password.(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), err
+		}
+	}
+
+	if sshPublicKeysOk {
+		if err := client.setUserSSHKeys(ctx, username, sshPublicKeys); err != nil {
+			return logical.ErrorResponse(err.Error()), err
+		}
+		user.SSHPublicKeys = sshPublicKeys
+	}
+
+	if fullnameOk || emailOk {
+		if fullnameOk {
+			user.Fullname = fullname.(string)
+		}
+		if emailOk {
+			user.Email = email.(string)
+		}
+
+		if err := client.updateUser(ctx, username, user.Fullname, user.Email); err != nil {
+			return logical.ErrorResponse(err.Error()), err
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(b.getUserPath(username), user)
+	if err != nil {
+		return logical.ErrorResponse("error creating user storage entry"), err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return logical.ErrorResponse("error writing user to internal storage"), err
+	}
+
+	return &logical.Response{
+		Data: user.toResponseData(),
+	}, nil
+}
+
+// pathUsersRotate sets a generated password on an existing user and returns it once
+func (b *jenkinsBackend) pathUsersRotate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	username := d.Get("name").(string)
+
+	if resp, err := b.checkProtectedUser(ctx, req.Storage, username, "rotated"); resp != nil || err != nil {
+		return resp, err
+	}
+
+	user, err := b.getUserFromStorage(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return logical.ErrorResponse("unknown user"), nil
+	}
+
+	if resp, err := b.checkUserOwner(ctx, req, user); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if !user.RevokedAt.IsZero() {
+		return revokedUserResponse(user), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.setUserPassword(ctx, username, password); err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"username": username,
+			"password": password,
+		},
+	}, nil
 }
 
-// createJenkinsUser creates a new Jenkins user to store into the Vault backend, generates
-// a response with the user information, and checks the TTL and MaxTTL attributes.
-func (b *jenkinsBackend) createJenkinsUser(ctx context.Context, req *logical.Request, jenkinsUser jenkinsUser) (*logical.Response, error) {
+// pathUsersImport writes existing Jenkins users selected by name or filter into the inventory
+func (b *jenkinsBackend) pathUsersImport(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	usernames := d.Get("usernames").([]string)
+	filter := d.Get("filter").(string)
+
+	if (len(usernames) == 0) == (filter == "") {
+		return logical.ErrorResponse("exactly one of usernames or filter must be set"), nil
+	}
+
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+	if ttl < 0 {
+		return logical.ErrorResponse("ttl can not be negative"), nil
+	}
+
+	var filterRegex *regexp.Regexp
+	if filter != "" {
+		var err error
+		filterRegex, err = regexp.Compile(filter)
+		if err != nil {
+			return logical.ErrorResponse("invalid filter: %s", err), nil
+		}
+	}
+
+	entityID := d.Get("entity_id").(string)
+	if entityID == "" {
+		entityID = req.EntityID
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if filterRegex != nil {
+		users, err := client.listUsers(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			if filterRegex.MatchString(user.ID) {
+				usernames = append(usernames, user.ID)
+			}
+		}
+	}
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	imported := []string{}
+	skipped := map[string]interface{}{}
+	for _, username := range usernames {
+		if config.isProtectedUser(username) {
+			skipped[username] = "protected"
+			continue
+		}
+
+		existing, err := b.getUserFromStorage(ctx, req.Storage, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil {
+			skipped[username] = "already managed by Vault"
+			continue
+		}
+
+		info, err := client.getUser(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if info == nil {
+			skipped[username] = "not found in Jenkins"
+			continue
+		}
+
+		if _, err := b.importUser(ctx, req.Storage, client, info, entityID, expiresAt, d.Get("delete_on_revoke").(bool)); err != nil {
+			return nil, fmt.Errorf("error importing user %q, users imported so far: %v: %w", username, imported, err)
+		}
+
+		imported = append(imported, info.ID)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"imported": imported,
+			"skipped":  skipped,
+		},
+	}, nil
+}
+
+// pathUsersBulkWrite creates several users given as a list or as CSV text under a single user lease
+func (b *jenkinsBackend) pathUsersBulkWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawUsers := d.Get("users").([]interface{})
+	csvText := d.Get("csv").(string)
+
+	if (len(rawUsers) == 0) == (csvText == "") {
+		return logical.ErrorResponse("exactly one of users or csv must be set"), nil
+	}
+
+	var entries []*userBulkEntry
+	var err error
+	if csvText != "" {
+		entries, err = parseUserBulkCSV(csvText)
+	} else {
+		entries, err = parseUserBulkJSON(rawUsers)
+	}
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateUserBulkEntries(entries); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+	maxTtl := time.Duration(d.Get("max_ttl").(int)) * time.Second
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	leaseID, err := newUserLeaseID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every user keeps its own inventory entry, a response only carries one lease
+	internalData := newLeaseInternalData(ttl, maxTtl)
+	internalData.LeaseID = leaseID
+
+	users, walIDs, errs := b.createUserBulk(ctx, req, config, internalData, entries)
+
+	var merr *multierror.Error
+	for i, err := range errs {
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("user %q: %w", entries[i].Username, err))
+		}
+	}
+
+	if merr.ErrorOrNil() != nil && d.Get("all_or_nothing").(bool) {
+		if err := b.rollbackUserBulk(ctx, req.Storage, users, walIDs); err != nil {
+			return nil, fmt.Errorf("error creating users: %s, error rolling back created users, rollback will be retried: %w", merr, err)
+		}
+		return logical.ErrorResponse("error creating users, created users were rolled back: %s", merr), nil
+	}
+
+	// The whole request is complete, so its users no longer need to be rolled back
+	for _, walID := range walIDs {
+		if walID != "" {
+			b.deleteWAL(ctx, req.Storage, walID)
+		}
+	}
+
+	respUsers := make([]map[string]interface{}, 0, len(entries))
+	for i, user := range users {
+		if user == nil {
+			respUsers = append(respUsers, map[string]interface{}{
+				"username": entries[i].Username,
+				"error":    errs[i].Error(),
+			})
+			continue
+		}
+
+		respUser := user.toResponseData()
+		respUser["password"] = user.Password
+		respUsers = append(respUsers, respUser)
+		internalData.Usernames = append(internalData.Usernames, user.Username)
+	}
+
+	respData := map[string]interface{}{
+		"users": respUsers,
+	}
+
+	// Without any user created there is nothing to lease
+	if len(internalData.Usernames) == 0 {
+		return &logical.Response{Data: respData}, nil
+	}
+
+	resp := b.Secret(jenkinsUserType).Response(respData, internalData.toMap())
+
+	if ttl > 0 {
+		resp.Secret.TTL = ttl
+	}
+	if maxTtl > 0 {
+		resp.Secret.MaxTTL = maxTtl
+	}
+
+	return resp, nil
+}
+
+// createJenkinsUser creates a new Jenkins user following the policy
+// to store into the Vault backend and generates a response with the user information.
+func (b *jenkinsBackend) createJenkinsUser(ctx context.Context, req *logical.Request, jenkinsUser jenkinsUser, policy *userPolicy) (*logical.Response, error) {
+	// Refuse users the policy doesn't allow before reaching Jenkins
+	if err := policy.validate(jenkinsUser.Username, jenkinsUser.Email); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := client.getUser(ctx, jenkinsUser.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		return logical.ErrorResponse("user %q already exists in Jenkins, import it with /%s/import", jenkinsUser.Username, usersPrefix), nil
+	}
+
+	walID, err := putUserWAL(ctx, req.Storage, jenkinsUser.Username)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := b.createUser(ctx, req.Storage, jenkinsUser)
 	if err != nil {
 		return nil, err
 	}
 
+	resp, err := b.userLeaseResponse(ctx, req, user, jenkinsUser)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+
+	b.deleteWAL(ctx, req.Storage, walID)
+
+	return resp, nil
+}
+
+// userLeaseResponse stores a user created in Jenkins into the inventory
+// and generates a response with its lease, using the TTLs of the request.
+func (b *jenkinsBackend) userLeaseResponse(ctx context.Context, req *logical.Request, user *jenkinsUser, jenkinsUser jenkinsUser) (*logical.Response, error) {
 	// We won't store the password
 	// Need to store username to revoke later, ttl to renew later
-	internalData := map[string]interface{}{
-		"username": user.Username,
-		"fullname": user.Fullname,
-		"email":    user.Email,
-		"ttl":      user.TTL,
-		"max_ttl":  user.MaxTTL,
+	leaseID, err := newUserLeaseID()
+	if err != nil {
+		return nil, err
 	}
 
+	internalData := newLeaseInternalData(jenkinsUser.TTL, jenkinsUser.MaxTTL)
+	internalData.Username = user.Username
+	internalData.Fullname = user.Fullname
+	internalData.Email = user.Email
+	internalData.LeaseID = leaseID
+
 	// Create secret with lease
-	resp := b.Secret(jenkinsUserType).Response(user.toResponseData(), internalData)
+	resp := b.Secret(jenkinsUserType).Response(user.toResponseData(), internalData.toMap())
 
-	// Create thing to store
-	entry, err := logical.StorageEntryJSON(b.getUserPath(jenkinsUser.Username), internalData)
-	if err != nil {
-		return logical.ErrorResponse("error creating user storage entry"), err
-	}
+	// Create thing to store, without the password
+	inventory := *user
+	inventory.Password = ""
+	inventory.TTL = jenkinsUser.TTL
+	inventory.MaxTTL = jenkinsUser.MaxTTL
+	inventory.RoleName = jenkinsUser.RoleName
+	inventory.EntityID = req.EntityID
+	inventory.LeaseID = leaseID
+	inventory.CreationTime = time.Now()
 
 	// Write to storage to view user inventory
-	err = req.Storage.Put(ctx, entry)
-	if err != nil {
+	if err := b.putUser(ctx, req.Storage, &inventory); err != nil {
 		return logical.ErrorResponse("error writing user to internal storage"), err
 	}
 
@@ -204,7 +1043,7 @@ func (b *jenkinsBackend) createJenkinsUser(ctx context.Context, req *logical.Req
 	return resp, nil
 }
 
-// createUser uses the Jenkins client create a new user
+// createUser uses the Jenkins client create a new user and authorizes its SSH public keys
 func (b *jenkinsBackend) createUser(ctx context.Context, s logical.Storage, userConfig jenkinsUser) (*jenkinsUser, error) {
 	client, err := b.getClient(ctx, s)
 	if err != nil {
@@ -222,12 +1061,23 @@ func (b *jenkinsBackend) createUser(ctx context.Context, s logical.Storage, user
 		return nil, errors.New("error creating Jenkins user")
 	}
 
+	if len(userConfig.SSHPublicKeys) > 0 {
+		if err := client.setUserSSHKeys(ctx, user.Username, userConfig.SSHPublicKeys); err != nil {
+			// The user is of no use without its keys, so don't leave it behind
+			if deleteErr := deleteUser(ctx, client, user.Username); deleteErr != nil {
+				return nil, fmt.Errorf("%v, error removing user: %w", err, deleteErr)
+			}
+			return nil, err
+		}
+		user.SSHPublicKeys = userConfig.SSHPublicKeys
+	}
+
 	return user, nil
 }
 
-// parseUsername gets Jenkins username from /users request path
+// parseUsername gets Jenkins username from /users or /manage/users request path
 func (b *jenkinsBackend) parseUsernameFromPath(path string) string {
-	return strings.TrimPrefix(path, fmt.Sprintf("%s/", usersPrefix))
+	return strings.TrimPrefix(strings.TrimPrefix(path, fmt.Sprintf("%s/", managePrefix)), fmt.Sprintf("%s/", usersPrefix))
 }
 
 const (
@@ -238,6 +1088,66 @@ Create a Jenkins User.
 	pathUsersHelpDesc = `
 This path generates a Jenkins user
 using the root user configured under the /config mount.
+Writing to an existing user updates its password, fullname or email.
+Deleting a user revokes it with the revoke_action of /config. Only
+force deletes a user kept in Jenkins by the lock or disable actions,
+or a Jenkins user that wasn't created by Vault. Existing users are
+brought under Vault with /users/import.
+Users listed in protected_users on /config, and the configured user,
+can never be created, deleted or rotated.
+`
+
+	pathUsersBulkHelpSyn = `
+Create several Jenkins users.
+`
+
+	pathUsersBulkHelpDesc = `
+This path creates up to 100 Jenkins users given as a list of objects or
+as CSV text, several at a time. Users that can't be created are reported
+with their error, unless all_or_nothing is set, in which case every user
+created by the request is deleted and the request fails.
+
+A Vault response carries a single lease, so the users are returned under
+one user lease with the ttl and max_ttl of the request. The lease revokes
+and renews each of them like the lease of a user under /users/<name>,
+leaving alone a user revoked or created again under the same name since.
+`
+
+	pathUsersDriftHelpSyn = `
+Report drift between the users inventory and Jenkins.
+`
+
+	pathUsersDriftHelpDesc = `
+This path compares the users in the /users inventory with the users of
+Jenkins. It reports users missing from Jenkins, users whose fullname or
+email differ, and Jenkins users carrying the plugin's marker that are not
+in the inventory, which tidy would delete.
+`
+
+	pathUsersImportHelpSyn = `
+Import existing Jenkins users into the inventory.
+`
+
+	pathUsersImportHelpDesc = `
+This path writes existing Jenkins users, selected by usernames or by a
+filter on their names, into the /users inventory so they can be updated,
+rotated or deleted through the plugin. Imported users have no lease. When
+ttl is set they are revoked once it has passed. Protected users and users
+already in the inventory are skipped.
+
+Revoking or deleting an imported user only restores its original
+description and removes it from the inventory, leaving the account in
+Jenkins. Users imported with delete_on_revoke are revoked with the
+revoke_action of /config instead, which deletes them by default.
+`
+
+	pathUsersRotateHelpSyn = `
+Rotate the password of a Jenkins user.
+`
+
+	pathUsersRotateHelpDesc = `
+This path sets a new generated password on a Jenkins user created
+under the /users mount and returns it. The password is not stored.
 `
 
 	pathUsersListHelpSyn = `
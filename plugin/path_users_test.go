@@ -3,10 +3,14 @@ package jenkinssecretsengine
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -14,6 +18,11 @@ const (
 	testUserPassword = "testPassword"
 	testUserFullname = "testFullname"
 	testUserEmail    = "testEmail@testemail.com"
+
+	testUserUpdatedFullname = "testUpdatedFullname"
+	testUserUpdatedEmail    = "testUpdatedEmail@testemail.com"
+
+	testUserSSHPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAQHk5VECWl6ixu2EohozxDCbWVniGvFmM8WdSdsnVpw testuser@example.com"
 )
 
 // TestUser mocks the creation, read operations for a Jenkins user
@@ -31,23 +40,671 @@ func TestUser(t *testing.T) {
 		})
 		assert.NoError(t, err)
 
-		// Users are ephemeral, will error if exists
-		err = testUserUpdate(t, b, s, userPath, map[string]interface{}{
-			"password": testUserPassword,
+		err = testUserRead(t, b, s, userPath, map[string]interface{}{
+			"username": testUserUsername,
 			"fullname": testUserFullname,
 			"email":    testUserEmail,
 		})
-		assert.Error(t, err)
+		assert.NoError(t, err)
+
+		// Updating an existing user changes it in place
+		err = testUserUpdate(t, b, s, userPath, map[string]interface{}{
+			"fullname": testUserUpdatedFullname,
+			"email":    testUserUpdatedEmail,
+		})
+		assert.NoError(t, err)
 
 		err = testUserRead(t, b, s, userPath, map[string]interface{}{
 			"username": testUserUsername,
+			"fullname": testUserUpdatedFullname,
+			"email":    testUserUpdatedEmail,
+		})
+		assert.NoError(t, err)
+
+		client, err := b.getClient(context.Background(), s)
+		assert.NoError(t, err)
+		user, err := client.getUser(context.Background(), testUserUsername)
+		assert.NoError(t, err)
+		assert.Equal(t, testUserUpdatedFullname, user.Fullname)
+		assert.Equal(t, testUserUpdatedEmail, user.Email)
+
+		// SSH public keys are authorized on update and kept in the inventory
+		err = testUserUpdate(t, b, s, userPath, map[string]interface{}{
+			"ssh_public_keys": testUserSSHPublicKey,
+		})
+		assert.NoError(t, err)
+
+		err = testUserRead(t, b, s, userPath, map[string]interface{}{
+			"username":        testUserUsername,
+			"fullname":        testUserUpdatedFullname,
+			"email":           testUserUpdatedEmail,
+			"ssh_public_keys": []string{testUserSSHPublicKey},
+		})
+		assert.NoError(t, err)
+
+		err = testUserUpdate(t, b, s, userPath, map[string]interface{}{
+			"ssh_public_keys": "ssh-ed25519 notakey",
+		})
+		assert.Error(t, err)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      userPath,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"check_jenkins": true,
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, true, resp.Data["jenkins_exists"])
+		assert.Empty(t, resp.Data["drift"])
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      fmt.Sprintf("%s/drift", usersPrefix),
+			Storage:   s,
+		})
+		assert.NoError(t, err)
+		assert.NotContains(t, resp.Data["missing_in_jenkins"], testUserUsername)
+		assert.NotContains(t, resp.Data["mismatched"], testUserUsername)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("%s/rotate", userPath),
+			Storage:   s,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, testUserUsername, resp.Data["username"])
+		assert.NotEqual(t, testUserPassword, resp.Data["password"])
+
+		err = testUserDelete(t, b, s, userPath)
+		assert.NoError(t, err)
+	})
+}
+
+// TestUserList ensures the inventory is listed with key_info, filters and pages
+func TestUserList(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	now := time.Now()
+	for _, user := range []*jenkinsUser{
+		{Username: "alice", Email: "alice@example.com", RoleName: "ci", CreationTime: now.Add(-2 * time.Hour)},
+		{Username: "bob", Email: "bob@other.com", CreationTime: now.Add(-2 * time.Hour)},
+		{Username: "carol", Email: "carol@Example.com", RoleName: "ci", CreationTime: now},
+	} {
+		entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usersPrefix, user.Username), user)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Put(context.Background(), entry))
+	}
+
+	tests := []struct {
+		data     map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{}, []string{"alice", "bob", "carol"}},
+		{map[string]interface{}{"email_domain": "example.com"}, []string{"alice", "carol"}},
+		{map[string]interface{}{"role": "ci"}, []string{"alice", "carol"}},
+		{map[string]interface{}{"created_before": now.Add(-time.Hour).Format(time.RFC3339)}, []string{"alice", "bob"}},
+		{map[string]interface{}{"limit": 2}, []string{"alice", "bob"}},
+		{map[string]interface{}{"limit": 2, "after": "bob"}, []string{"carol"}},
+		{map[string]interface{}{"email_domain": "example.com", "limit": 1, "after": "alice"}, []string{"carol"}},
+		{map[string]interface{}{"role": "ci", "limit": 1, "after": "alice"}, []string{"carol"}},
+	}
+
+	for _, test := range tests {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      fmt.Sprintf("%s/", usersPrefix),
+			Storage:   s,
+			Data:      test.data,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, resp.Data["keys"], test.data)
+		assert.Len(t, resp.Data["key_info"], len(test.expected))
+	}
+}
+
+// TestUserDrift ensures differences between the inventory and Jenkins are reported
+func TestUserDrift(t *testing.T) {
+	user := &jenkinsUser{
+		Username: testUserUsername,
+		Fullname: testUserFullname,
+		Email:    testUserEmail,
+	}
+
+	assert.Empty(t, user.drift(&jenkinsUserInfo{
+		ID:       testUserUsername,
+		Fullname: testUserFullname,
+		Email:    testUserEmail,
+	}))
+
+	assert.Equal(t, map[string]interface{}{
+		"email": map[string]interface{}{
+			"inventory": testUserEmail,
+			"jenkins":   testUserUpdatedEmail,
+		},
+	}, user.drift(&jenkinsUserInfo{
+		ID:       testUserUsername,
+		Fullname: testUserFullname,
+		Email:    testUserUpdatedEmail,
+	}))
+}
+
+// TestProtectedUser ensures protected and unmanaged users are left alone
+func TestProtectedUser(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username":        testUsername,
+		"password":        testPassword,
+		"url":             testURL,
+		"protected_users": "jenkins-ops",
+		"validate":        false,
+	})
+	assert.NoError(t, err)
+
+	t.Run("Configured user can not be deleted", func(t *testing.T) {
+		err := testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, testUsername))
+		assert.Error(t, err)
+
+		// Jenkins compares usernames case insensitively
+		err = testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, strings.ToUpper(testUsername)))
+		assert.Error(t, err)
+	})
+
+	t.Run("Protected user can not be created, deleted or rotated", func(t *testing.T) {
+		userPath := fmt.Sprintf("%s/%s", usersPrefix, "jenkins-ops")
+
+		err := testUserCreate(t, b, s, userPath, map[string]interface{}{
+			"password": testUserPassword,
 			"fullname": testUserFullname,
 			"email":    testUserEmail,
 		})
+		assert.Error(t, err)
+
+		err = testUserDelete(t, b, s, userPath)
+		assert.Error(t, err)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("%s/rotate", userPath),
+			Storage:   s,
+		})
 		assert.NoError(t, err)
+		assert.True(t, resp.IsError())
+	})
+
+	t.Run("Unmanaged user is not deleted without force", func(t *testing.T) {
+		err := testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, "unmanaged"))
+		assert.Error(t, err)
+	})
+}
+
+// TestUserOwnerOnly refuses requests under /users from entities not owning the user
+func TestUserOwnerOnly(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username":   testUsername,
+		"password":   testPassword,
+		"url":        testURL,
+		"owner_only": true,
+		"validate":   false,
+	})
+	assert.NoError(t, err)
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usersPrefix, "alice"), &jenkinsUser{
+		Username: "alice",
+		EntityID: "owner",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put(context.Background(), entry))
+
+	request := func(operation logical.Operation, path, entityID string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   s,
+			EntityID:  entityID,
+		})
+	}
+
+	t.Run("Owner can read user", func(t *testing.T) {
+		resp, err := request(logical.ReadOperation, fmt.Sprintf("%s/%s", usersPrefix, "alice"), "owner")
+		assert.NoError(t, err)
+		assert.Equal(t, "owner", resp.Data["entity_id"])
+	})
+
+	t.Run("Other entities can not read, rotate or delete user", func(t *testing.T) {
+		for _, test := range []struct {
+			operation logical.Operation
+			path      string
+		}{
+			{logical.ReadOperation, fmt.Sprintf("%s/%s", usersPrefix, "alice")},
+			{logical.UpdateOperation, fmt.Sprintf("%s/%s/rotate", usersPrefix, "alice")},
+			{logical.DeleteOperation, fmt.Sprintf("%s/%s", usersPrefix, "alice")},
+		} {
+			resp, err := request(test.operation, test.path, "other")
+			assert.ErrorIs(t, err, logical.ErrPermissionDenied, test.path)
+			assert.True(t, resp.IsError(), test.path)
+		}
+	})
+
+	t.Run("Other entities can read user under manage", func(t *testing.T) {
+		resp, err := request(logical.ReadOperation, fmt.Sprintf("%s/%s/%s", managePrefix, usersPrefix, "alice"), "other")
+		assert.NoError(t, err)
+		assert.Equal(t, "alice", resp.Data["username"])
+	})
+
+	t.Run("Owner is listed in key_info", func(t *testing.T) {
+		resp, err := request(logical.ListOperation, fmt.Sprintf("%s/", usersPrefix), "other")
+		assert.NoError(t, err)
+		keyInfo := resp.Data["key_info"].(map[string]interface{})
+		assert.Equal(t, "owner", keyInfo["alice"].(map[string]interface{})["entity_id"])
+	})
+}
+
+// TestUserUpdateCreateOnlyFields ensures fields only used on creation are refused on update
+func TestUserUpdateCreateOnlyFields(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username": testUsername,
+		"password": testPassword,
+		"url":      testURL,
+		"validate": false,
+	})
+	assert.NoError(t, err)
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usersPrefix, "alice"), &jenkinsUser{Username: "alice"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put(context.Background(), entry))
+
+	for _, data := range []map[string]interface{}{
+		{"ttl": "1h"},
+		{"max_ttl": "2h"},
+		{"format": formatNetrc},
+	} {
+		err := testUserUpdate(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, "alice"), data)
+		assert.Error(t, err, data)
+	}
+}
+
+// TestUserUpdateValidation ensures updates are validated before anything is changed in Jenkins
+func TestUserUpdateValidation(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username":              testUsername,
+		"password":              testPassword,
+		"url":                   testURL,
+		"allowed_email_domains": "example.com",
+		"validate":              false,
+	})
+	assert.NoError(t, err)
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usersPrefix, "alice"), &jenkinsUser{Username: "alice"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put(context.Background(), entry))
+
+	err = testUserUpdate(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, "alice"), map[string]interface{}{
+		"password": testUserPassword,
+		"email":    "alice@other.com",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "email:")
+	err = testUserUpdate(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, "alice"), map[string]interface{}{
+		"password":        testUserPassword,
+		"ssh_public_keys": "ssh-ed25519 notakey",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ssh_public_keys:")
+}
 
+// TestUserRevokeAction locks a user on revocation instead of deleting it
+func TestUserRevokeAction(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username":      testUsername,
+		"password":      testPassword,
+		"url":           testURL,
+		"revoke_action": "archive",
+		"validate":      false,
+	})
+	assert.Error(t, err)
+
+	err = testConfigCreate(t, b, s, map[string]interface{}{
+		"username":      testUsername,
+		"password":      testPassword,
+		"url":           testURL,
+		"revoke_action": revokeActionLock,
+	})
+	require.NoError(t, err)
+
+	userPath := fmt.Sprintf("%s/%s", usersPrefix, testUserUsername)
+
+	t.Run("Revoked user is locked", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      userPath,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"password": testUserPassword,
+				"fullname": testUserFullname,
+				"email":    testUserEmail,
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError())
+		require.NotNil(t, resp.Secret)
+		secret := resp.Secret
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    secret,
+		})
+		assert.NoError(t, err)
+
+		client, err := b.getClient(context.Background(), s)
+		require.NoError(t, err)
+		user, err := client.getUser(context.Background(), testUserUsername)
+		assert.NoError(t, err)
+		assert.NotNil(t, user)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      userPath,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, revokeActionLock, resp.Data["revoke_action"])
+		assert.NotEmpty(t, resp.Data["revoked_at"])
+
+		err = testUserUpdate(t, b, s, userPath, map[string]interface{}{
+			"password": testUserPassword,
+		})
+		assert.Error(t, err)
+
+		// The lease no longer acts on a revoked user
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   s,
+			Secret:    secret,
+		})
+		assert.Error(t, err)
+
+		// Only force removes a locked user from Jenkins
 		err = testUserDelete(t, b, s, userPath)
+		assert.Error(t, err)
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      userPath,
+			Storage:   s,
+			Data:      map[string]interface{}{"force": true},
+		})
+		assert.NoError(t, err)
+
+		user, err = client.getUser(context.Background(), testUserUsername)
+		assert.NoError(t, err)
+		assert.Nil(t, user)
+	})
+
+	t.Run("Revoked imported user gets its description back", func(t *testing.T) {
+		importedUsername := "testImportedLockedUser"
+		client, err := b.getClient(context.Background(), s)
+		require.NoError(t, err)
+		_, err = client.CreateUser(context.Background(), importedUsername, testUserPassword, testUserFullname, testUserEmail)
+		require.NoError(t, err)
+		assert.NoError(t, client.setUserDescription(context.Background(), importedUsername, "Legacy account"))
+
+		err = testUserUpdate(t, b, s, fmt.Sprintf("%s/import", usersPrefix), map[string]interface{}{
+			"usernames": importedUsername,
+		})
+		assert.NoError(t, err)
+
+		assert.NoError(t, b.revokeUser(context.Background(), s, client, importedUsername))
+
+		user, err := client.getUser(context.Background(), importedUsername)
+		assert.NoError(t, err)
+		if assert.NotNil(t, user) {
+			assert.Equal(t, "Legacy account", user.Description)
+		}
+
+		// Revoking unlinked the user rather than locking it
+		entry, err := b.getUserFromStorage(context.Background(), s, importedUsername)
+		assert.NoError(t, err)
+		assert.Nil(t, entry)
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      fmt.Sprintf("%s/%s", usersPrefix, importedUsername),
+			Storage:   s,
+			Data:      map[string]interface{}{"force": true},
+		})
+		assert.NoError(t, err)
+	})
+}
+
+// TestUserExpireProtected leaves an expired user alone once it is protected
+func TestUserExpireProtected(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username":        testUsername,
+		"password":        testPassword,
+		"url":             testURL,
+		"protected_users": "jenkins-ops",
+		"validate":        false,
+	})
+	assert.NoError(t, err)
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usersPrefix, "jenkins-ops"), &jenkinsUser{
+		Username:  "jenkins-ops",
+		ExpiresAt: time.Now().Add(-time.Hour),
+		Imported:  true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put(context.Background(), entry))
+
+	assert.NoError(t, b.expireUsers(context.Background(), s))
+
+	user, err := b.getUserFromStorage(context.Background(), s, "jenkins-ops")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user) {
+		assert.True(t, user.RevokedAt.IsZero())
+	}
+
+	err = b.revokeUser(context.Background(), s, nil, "jenkins-ops")
+	assert.Error(t, err)
+}
+
+// TestUserBulk creates several users in one request and revokes them through their lease
+func TestUserBulk(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	bulkPath := fmt.Sprintf("%s/bulk", usersPrefix)
+
+	t.Run("Create and revoke user bulk", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      bulkPath,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"csv": "username,fullname,email\ntestBulkUser1,Bulk One,one@example.com\ntestBulkUser2,Bulk Two,two@example.com\n",
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		users := resp.Data["users"].([]map[string]interface{})
+		assert.Len(t, users, 2)
+		assert.NotEmpty(t, users[0]["password"])
+		assert.NotContains(t, users[1], "error")
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		assert.NoError(t, err)
+
+		client, err := b.getClient(context.Background(), s)
+		assert.NoError(t, err)
+		for _, user := range users {
+			info, err := client.getUser(context.Background(), user["username"].(string))
+			assert.NoError(t, err)
+			assert.Nil(t, info)
+		}
+	})
+
+	t.Run("All or nothing rolls back every user", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      bulkPath,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"users": []interface{}{
+					map[string]interface{}{"username": "testBulkUser3"},
+					map[string]interface{}{"username": testUsername},
+				},
+				"all_or_nothing": true,
+			},
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.IsError())
+
+		user, err := b.getUserFromStorage(context.Background(), s, "testBulkUser3")
+		assert.NoError(t, err)
+		assert.Nil(t, user)
+	})
+}
+
+// TestUserBulkLeaseOwnership leaves alone a user created again under the name of a bulk user
+func TestUserBulkLeaseOwnership(t *testing.T) {
+	b, s := getTestBackend(t)
+
+	err := testConfigCreate(t, b, s, map[string]interface{}{
+		"username": testUsername,
+		"password": testPassword,
+		"url":      testURL,
+		"validate": false,
+	})
+	assert.NoError(t, err)
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usersPrefix, "trainee-1"), &jenkinsUser{
+		Username: "trainee-1",
+		LeaseID:  "newer",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put(context.Background(), entry))
+
+	internalData := newLeaseInternalData(time.Hour, 0)
+	internalData.Usernames = []string{"trainee-1"}
+	internalData.LeaseID = "older"
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    b.Secret(jenkinsUserType).Response(nil, internalData.toMap()).Secret,
+	})
+	assert.NoError(t, err)
+
+	user, err := b.getUserFromStorage(context.Background(), s, "trainee-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user) {
+		assert.True(t, user.RevokedAt.IsZero())
+	}
+}
+
+// TestUserImport imports an existing Jenkins user into the inventory
+func TestUserImport(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	importPath := fmt.Sprintf("%s/import", usersPrefix)
+	importedUsername := "testImportedUser"
+
+	t.Run("Usernames or filter are required", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      importPath,
+			Storage:   s,
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.IsError())
+	})
+
+	t.Run("Import user", func(t *testing.T) {
+		client, err := b.getClient(context.Background(), s)
+		assert.NoError(t, err)
+		_, err = client.CreateUser(context.Background(), importedUsername, testUserPassword, testUserFullname, testUserEmail)
+		assert.NoError(t, err)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      importPath,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"usernames": []string{importedUsername, testUsername, "missingUser"},
+				"ttl":       "1h",
+				"entity_id": "test-entity",
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		assert.Equal(t, []string{importedUsername}, resp.Data["imported"])
+		assert.Equal(t, map[string]interface{}{
+			testUsername:  "protected",
+			"missingUser": "not found in Jenkins",
+		}, resp.Data["skipped"])
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      fmt.Sprintf("%s/%s", usersPrefix, importedUsername),
+			Storage:   s,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, testUserFullname, resp.Data["fullname"])
+		assert.Equal(t, "test-entity", resp.Data["entity_id"])
+		assert.NotEmpty(t, resp.Data["expires_at"])
+
+		// Deleting an imported user only unlinks it from Vault
+		err = testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, importedUsername))
+		assert.NoError(t, err)
+
+		user, err := client.getUser(context.Background(), importedUsername)
+		assert.NoError(t, err)
+		assert.NotNil(t, user)
+
+		entry, err := b.getUserFromStorage(context.Background(), s, importedUsername)
+		assert.NoError(t, err)
+		assert.Nil(t, entry)
+
+		// Users imported with delete_on_revoke are deleted like users created by Vault
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      importPath,
+			Storage:   s,
+			Data: map[string]interface{}{
+				"usernames":        importedUsername,
+				"delete_on_revoke": true,
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{importedUsername}, resp.Data["imported"])
+
+		err = testUserDelete(t, b, s, fmt.Sprintf("%s/%s", usersPrefix, importedUsername))
+		assert.NoError(t, err)
+
+		user, err = client.getUser(context.Background(), importedUsername)
 		assert.NoError(t, err)
+		assert.Nil(t, user)
 	})
 }
 
@@ -132,7 +789,7 @@ func testUserRead(t *testing.T, b logical.Backend, s logical.Storage, path strin
 
 		if !ok {
 			return fmt.Errorf(`expected data["%s"] = %v but was not included in read output"`, k, expectedV)
-		} else if expectedV != actualV {
+		} else if !reflect.DeepEqual(expectedV, actualV) {
 			return fmt.Errorf(`expected data["%s"] = %v, instead got %v"`, k, expectedV, actualV)
 		}
 	}
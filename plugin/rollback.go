@@ -0,0 +1,208 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	walTypeUser  = "user"
+	walTypeToken = "token"
+
+	// walRollbackMinAge is how long a request has to complete
+	// before the Jenkins objects it created are rolled back
+	walRollbackMinAge = 10 * time.Minute
+	// walTokenNonceLength is the length of the nonce ending the Jenkins name of new tokens
+	walTokenNonceLength = 8
+)
+
+// walUser records a Jenkins user about to be created
+type walUser struct {
+	Username string `json:"username" mapstructure:"username"`
+}
+
+// walToken records a Jenkins token about to be created. Its ID is only known once
+// created, so it is found again by its Jenkins name, which ends with a nonce.
+type walToken struct {
+	Username  string `json:"username" mapstructure:"username"`
+	TokenName string `json:"token_name" mapstructure:"token_name"`
+}
+
+// walRollback removes a Jenkins object whose creation request didn't
+// complete, unless it made it into the plugin's inventory.
+func (b *jenkinsBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walTypeUser:
+		return b.rollbackUser(ctx, req.Storage, data)
+	case walTypeToken:
+		return b.rollbackToken(ctx, req.Storage, data)
+	default:
+		return fmt.Errorf("unknown rollback type %q", kind)
+	}
+}
+
+// rollbackUser deletes a user created by the plugin that is not in the inventory
+func (b *jenkinsBackend) rollbackUser(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walUser
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	inventory, err := b.getUserFromStorage(ctx, s, entry.Username)
+	if err != nil {
+		return err
+	}
+
+	if inventory != nil {
+		return nil
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	user, err := client.getUser(ctx, entry.Username)
+	if err != nil {
+		return err
+	}
+
+	// Never touch a user that existed before the request or wasn't created by the plugin
+	if user == nil || user.Description != jenkinsUserDescription {
+		return nil
+	}
+
+	if err := deleteUser(ctx, client, entry.Username); err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("error rolling back user %q: %w", entry.Username, err)
+	}
+
+	b.Logger().Info("rolled back user", "username", entry.Username)
+
+	return nil
+}
+
+// rollbackToken revokes tokens created by the request that are not in the inventory
+func (b *jenkinsBackend) rollbackToken(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walToken
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		return fmt.Errorf("jenkins configuration was nil in /%s", configPrefix)
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	// Tokens of other users are revoked through the script console
+	owner := entry.Username
+	if sameUsername(owner, config.Username) {
+		owner = ""
+	}
+
+	tokens, err := client.listAPITokens(ctx, entry.Username)
+	if err != nil {
+		return err
+	}
+
+	_, staticTokenIDs, err := staticRoleTokens(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var pending bool
+	for _, token := range tokens {
+		if token.Name != entry.TokenName {
+			continue
+		}
+
+		// The request may still be completing, the WAL entry is kept to look again later
+		if time.Since(token.creationTime()) < walRollbackMinAge {
+			pending = true
+			continue
+		}
+
+		inventory, err := getTokenFromStorage(ctx, s, token.UUID)
+		if err != nil {
+			return err
+		}
+
+		if inventory != nil || staticTokenIDs[token.UUID] {
+			continue
+		}
+
+		if err := deleteTokenOf(ctx, client, owner, token.UUID); err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("error rolling back token %q: %w", token.UUID, err)
+		}
+
+		b.Logger().Info("rolled back token", "token_id", token.UUID, "token_name", token.Name)
+	}
+
+	if pending {
+		return fmt.Errorf("token %q was created too recently to be rolled back", entry.TokenName)
+	}
+
+	return nil
+}
+
+// putUserWAL records a Jenkins user about to be created. Until the returned WAL entry is
+// deleted, the user is rolled back unless it makes it into the inventory.
+func putUserWAL(ctx context.Context, s logical.Storage, username string) (string, error) {
+	return putWAL(ctx, s, walTypeUser, &walUser{
+		Username: username,
+	})
+}
+
+// putTokenWAL records a Jenkins token about to be created for a user, rolled back like a
+// user. It returns the name to create the token under in Jenkins, which ends with a nonce
+// so the rollback never takes a token of another request for it.
+func putTokenWAL(ctx context.Context, s logical.Storage, username, tokenName string) (string, string, error) {
+	nonce, err := base62.Random(walTokenNonceLength)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating token nonce: %w", err)
+	}
+
+	jenkinsName := fmt.Sprintf("%s%s-%s", jenkinsTokenNamePrefix, tokenName, nonce)
+	walID, err := putWAL(ctx, s, walTypeToken, &walToken{
+		Username:  username,
+		TokenName: jenkinsName,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return walID, jenkinsName, nil
+}
+
+// putWAL records a Jenkins object about to be created
+func putWAL(ctx context.Context, s logical.Storage, kind string, data interface{}) (string, error) {
+	walID, err := framework.PutWAL(ctx, s, kind, data)
+	if err != nil {
+		return "", fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	return walID, nil
+}
+
+// deleteWAL removes the WAL entry of a completed request. The created object is in
+// the inventory at this point, so a failure only leaves work for the rollback.
+func (b *jenkinsBackend) deleteWAL(ctx context.Context, s logical.Storage, walID string) {
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		b.Logger().Warn("error deleting WAL entry", "id", walID, "error", err)
+	}
+}
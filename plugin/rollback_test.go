@@ -0,0 +1,34 @@
+package jenkinssecretsengine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+const testRollbackUsername = "testRollbackUsername"
+
+// TestRollbackUser tests that a user created without an inventory entry is rolled back
+func TestRollbackUser(t *testing.T) {
+	b, s := getTestBackend(t)
+	AddTestConfig(t, b, s)
+
+	ctx := context.Background()
+	client, err := b.getClient(ctx, s)
+	require.NoError(t, err)
+
+	// Simulate a request that failed after creating the user in Jenkins
+	_, err = createUser(ctx, client, testRollbackUsername, testUserPassword, testUserFullname, testUserEmail)
+	require.NoError(t, err)
+
+	err = b.walRollback(ctx, &logical.Request{Storage: s}, walTypeUser, map[string]interface{}{
+		"username": testRollbackUsername,
+	})
+	require.NoError(t, err)
+
+	user, err := client.getUser(ctx, testRollbackUsername)
+	require.NoError(t, err)
+	require.Nil(t, user)
+}
@@ -0,0 +1,33 @@
+package jenkinssecretsengine
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshPublicKeysFieldDescription describes the ssh_public_keys field of paths managing users
+const sshPublicKeysFieldDescription = "OpenSSH public keys authorized for the Jenkins CLI over SSH, as a list or as lines in the authorized_keys format"
+
+// parseSSHPublicKeys validates OpenSSH public keys given as a list, where each item may hold
+// several lines as in an authorized_keys file. Empty lines and comments are dropped.
+func parseSSHPublicKeys(raw []string) ([]string, error) {
+	keys := []string{}
+	for _, item := range raw {
+		for _, line := range strings.Split(item, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+				return nil, fmt.Errorf("ssh_public_keys: invalid key %d: %w", len(keys)+1, err)
+			}
+
+			keys = append(keys, line)
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,39 @@
+package jenkinssecretsengine
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestParseSSHPublicKeys tests validating SSH public keys given as a list or as authorized_keys lines
+func TestParseSSHPublicKeys(t *testing.T) {
+	var keys []string
+	for i := 0; i < 2; i++ {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		sshPublic, err := ssh.NewPublicKey(public)
+		require.NoError(t, err)
+		keys = append(keys, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic)))+" user@example.com")
+	}
+
+	parsed, err := parseSSHPublicKeys(keys)
+	require.NoError(t, err)
+	require.Equal(t, keys, parsed)
+
+	parsed, err = parseSSHPublicKeys([]string{"# engineers\n" + keys[0] + "\n\n " + keys[1] + " \n"})
+	require.NoError(t, err)
+	require.Equal(t, keys, parsed)
+
+	parsed, err = parseSSHPublicKeys(nil)
+	require.NoError(t, err)
+	require.Empty(t, parsed)
+
+	_, err = parseSSHPublicKeys([]string{keys[0], "ssh-ed25519 notakey"})
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "ssh_public_keys: invalid key 2"))
+}
@@ -0,0 +1,166 @@
+package jenkinssecretsengine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// builtinReservedUsernames can never be created under /users. Jenkins reserves
+// the first ones for itself, the others are endpoints under /users.
+var builtinReservedUsernames = []string{"system", "anonymous", "unknown", "import", "drift", "bulk"}
+
+// userPolicy restricts the usernames and emails of the users created under /users.
+// It can be set on the config and on roles, whose settings take precedence.
+type userPolicy struct {
+	AllowedEmailDomains []string `json:"allowed_email_domains"`
+	ReservedUsernames   []string `json:"reserved_usernames"`
+	UsernameRegex       string   `json:"username_regex"`
+	UsernamePrefix      string   `json:"username_prefix"`
+}
+
+// userPolicyFields returns the fields of paths configuring a user policy
+func userPolicyFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"allowed_email_domains": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Email domains users may be created with. Supports globs such as *.example.com. If not set, any email is allowed.",
+			Required:    false,
+		},
+		"username_regex": {
+			Type:        framework.TypeString,
+			Description: "Regular expression usernames of created users must match",
+			Required:    false,
+		},
+		"reserved_usernames": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "Usernames that can never be created, in addition to the names Jenkins and the plugin reserve. Supports globs such as admin*.",
+			Required:    false,
+		},
+		"username_prefix": {
+			Type:        framework.TypeString,
+			Description: "Prefix usernames of created users must start with",
+			Required:    false,
+		},
+	}
+}
+
+// update sets the policy from the fields of a request
+func (policy *userPolicy) update(d *framework.FieldData) error {
+	if allowedEmailDomains, ok := d.GetOk("allowed_email_domains"); ok {
+		policy.AllowedEmailDomains = allowedEmailDomains.([]string)
+	}
+
+	if usernameRegex, ok := d.GetOk("username_regex"); ok {
+		if _, err := regexp.Compile(usernameRegex.(string)); err != nil {
+			return fmt.Errorf("invalid username_regex: %w", err)
+		}
+		policy.UsernameRegex = usernameRegex.(string)
+	}
+
+	if reservedUsernames, ok := d.GetOk("reserved_usernames"); ok {
+		policy.ReservedUsernames = reservedUsernames.([]string)
+	}
+
+	if usernamePrefix, ok := d.GetOk("username_prefix"); ok {
+		policy.UsernamePrefix = usernamePrefix.(string)
+	}
+
+	return nil
+}
+
+// addResponseData adds the policy to the response data of a read
+func (policy *userPolicy) addResponseData(respData map[string]interface{}) {
+	respData["allowed_email_domains"] = policy.AllowedEmailDomains
+	respData["username_regex"] = policy.UsernameRegex
+	respData["reserved_usernames"] = policy.ReservedUsernames
+	respData["username_prefix"] = policy.UsernamePrefix
+}
+
+// resolveUserPolicy returns the policy of users created through a role, or
+// without one when role is nil. Reserved usernames of both apply.
+func resolveUserPolicy(config *jenkinsConfig, role *jenkinsRole) *userPolicy {
+	policy := config.UserPolicy
+	if role == nil {
+		return &policy
+	}
+
+	if len(role.UserPolicy.AllowedEmailDomains) > 0 {
+		policy.AllowedEmailDomains = role.UserPolicy.AllowedEmailDomains
+	}
+	if role.UserPolicy.UsernameRegex != "" {
+		policy.UsernameRegex = role.UserPolicy.UsernameRegex
+	}
+	if role.UserPolicy.UsernamePrefix != "" {
+		policy.UsernamePrefix = role.UserPolicy.UsernamePrefix
+	}
+	policy.ReservedUsernames = append(append([]string{}, policy.ReservedUsernames...), role.UserPolicy.ReservedUsernames...)
+
+	return &policy
+}
+
+// validateUsername returns an error naming the field if a username breaks the policy
+func (policy *userPolicy) validateUsername(username string) error {
+	if policy.UsernamePrefix != "" && !strings.HasPrefix(foldUsername(username), foldUsername(policy.UsernamePrefix)) {
+		return fmt.Errorf("username: must start with %q", policy.UsernamePrefix)
+	}
+
+	if policy.UsernameRegex != "" {
+		usernameRegex, err := regexp.Compile(policy.UsernameRegex)
+		if err != nil {
+			return fmt.Errorf("username: invalid username_regex: %w", err)
+		}
+
+		if !usernameRegex.MatchString(username) {
+			return fmt.Errorf("username: must match %q", policy.UsernameRegex)
+		}
+	}
+
+	reserved := append(append([]string{}, builtinReservedUsernames...), policy.ReservedUsernames...)
+	if strutil.StrListContainsGlob(lowercaseStrings(reserved), foldUsername(username)) {
+		return fmt.Errorf("username: %q is reserved", username)
+	}
+
+	return nil
+}
+
+// validateEmail returns an error naming the field if an email breaks the policy
+func (policy *userPolicy) validateEmail(email string) error {
+	if len(policy.AllowedEmailDomains) == 0 {
+		return nil
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 1 || at == len(email)-1 {
+		return fmt.Errorf("email: must be an address in one of the allowed domains: %s", strings.Join(policy.AllowedEmailDomains, ", "))
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	if !strutil.StrListContainsGlob(lowercaseStrings(policy.AllowedEmailDomains), domain) {
+		return fmt.Errorf("email: domain %q is not allowed, must be one of: %s", domain, strings.Join(policy.AllowedEmailDomains, ", "))
+	}
+
+	return nil
+}
+
+// validate returns an error naming the field if a new user breaks the policy
+func (policy *userPolicy) validate(username, email string) error {
+	if err := policy.validateUsername(username); err != nil {
+		return err
+	}
+
+	return policy.validateEmail(email)
+}
+
+// lowercaseStrings returns a copy of a list with every item in lower case
+func lowercaseStrings(list []string) []string {
+	lowercased := make([]string, 0, len(list))
+	for _, item := range list {
+		lowercased = append(lowercased, strings.ToLower(item))
+	}
+
+	return lowercased
+}
@@ -0,0 +1,47 @@
+package jenkinssecretsengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestUserPolicy tests validating usernames and emails against the config and role policies
+func TestUserPolicy(t *testing.T) {
+	config := &jenkinsConfig{
+		UserPolicy: userPolicy{
+			AllowedEmailDomains: []string{"example.com", "*.example.org"},
+			ReservedUsernames:   []string{"admin*"},
+			UsernamePrefix:      "tmp-",
+		},
+	}
+
+	policy := resolveUserPolicy(config, nil)
+	require.NoError(t, policy.validate("tmp-alice", "alice@example.com"))
+	require.NoError(t, policy.validate("TMP-bob", "bob@ci.Example.org"))
+	require.EqualError(t, policy.validate("alice", "alice@example.com"), `username: must start with "tmp-"`)
+	require.EqualError(t, policy.validate("tmp-alice", "alice@example.net"), `email: domain "example.net" is not allowed, must be one of: example.com, *.example.org`)
+	require.Error(t, policy.validate("tmp-alice", "alice"))
+	require.Error(t, policy.validate("tmp-alice", ""))
+
+	// Reserved names apply whatever the prefix
+	config.UserPolicy.UsernamePrefix = ""
+	require.EqualError(t, resolveUserPolicy(config, nil).validateUsername("Anonymous"), `username: "Anonymous" is reserved`)
+	require.Error(t, resolveUserPolicy(config, nil).validateUsername("administrator"))
+
+	// Role settings take precedence and reserved usernames add up
+	role := &jenkinsRole{
+		UserPolicy: userPolicy{
+			AllowedEmailDomains: []string{"training.example.com"},
+			ReservedUsernames:   []string{"trainee-0"},
+			UsernameRegex:       "^trainee-[0-9]+$",
+		},
+	}
+
+	policy = resolveUserPolicy(config, role)
+	require.NoError(t, policy.validate("trainee-1", "one@training.example.com"))
+	require.Error(t, policy.validate("trainee-1", "one@example.com"))
+	require.EqualError(t, policy.validateUsername("trainee"), `username: must match "^trainee-[0-9]+$"`)
+	require.EqualError(t, policy.validateUsername("trainee-0"), `username: "trainee-0" is reserved`)
+	require.Empty(t, config.UserPolicy.UsernameRegex)
+}